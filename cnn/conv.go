@@ -3,6 +3,8 @@ package cnn
 import (
 	"fmt"
 	"pneuma/common"
+	"runtime"
+	"sync"
 
 	"gonum.org/v1/gonum/blas/blas64"
 	"gonum.org/v1/gonum/mat"
@@ -20,7 +22,7 @@ func paddingCnt(size, core, stride int, padding ConvKernalPadding) (lp, rp, slip
 			panic(fmt.Sprintf("paddingCnt need core bigger than size, now core=%d, size=%d", core, size))
 		}
 		slip = (size-core)/stride + 1
-		nopadSize := (slip-1)*stride + core
+		nopadSize = (slip-1)*stride + core
 		if nopadSize == size {
 			return
 		}
@@ -46,10 +48,26 @@ const (
 	ConvKernalPadAll
 )
 
+// fills the padding, reflect mirrors the input without repeating the edge,
+// replicate repeats the edge, both pass the gradient of the padding back to the input
+type ConvKernalFill int16
+
+const (
+	ConvKernalFillZero ConvKernalFill = iota
+	ConvKernalFillConst
+	ConvKernalFillReflect
+	ConvKernalFillReplicate
+)
+
 type ConvKernalParam struct {
-	size    []int
-	stride  []int
-	padding ConvKernalPadding
+	size     []int
+	stride   []int
+	padding  ConvKernalPadding
+	dilation []int
+	groups   int
+	pads     [][2]int
+	fill     ConvKernalFill
+	fillVal  float64
 }
 
 func NewConvKParam(size, stride []int, padding ConvKernalPadding) ConvKernalParam {
@@ -60,15 +78,39 @@ func NewConvKParam(size, stride []int, padding ConvKernalPadding) ConvKernalPara
 	}
 }
 
+// Dilate spreads the kernel taps dilation apart in every spatial dim, the kernel then spans
+// (size-1)*dilation+1 for the slips and the padding with the same parameters
+func (p ConvKernalParam) Dilate(dilation []int) ConvKernalParam {
+	p.dilation = dilation
+	return p
+}
+
+// Pads sets the (left, right) padding of every spatial dim, it takes over the split of the padding mode
+func (p ConvKernalParam) Pads(pads [][2]int) ConvKernalParam {
+	p.pads = pads
+	return p
+}
+
+// Fill sets how the padding is filled, val is the constant of ConvKernalFillConst
+func (p ConvKernalParam) Fill(fill ConvKernalFill, val float64) ConvKernalParam {
+	p.fill = fill
+	p.fillVal = val
+	return p
+}
+
+// Group splits the input channels and the kernels into cnt groups, every kernel only sees
+// the channels of its group, cnt as the channel count is a depthwise conv
+func (p ConvKernalParam) Group(cnt int) ConvKernalParam {
+	if cnt <= 0 {
+		panic(fmt.Sprintf("conv group count need positive, but %d", cnt))
+	}
+	p.groups = cnt
+	return p
+}
+
 type ConvPackerCalInfo struct {
-	needFit     bool
-	pads        []int
 	fitPos      []int
-	orgPos      []int
 	step        int
-	pad         int
-	padOffset   int
-	fitStride   []int
 	kerOffset   int
 	kerCnt      []int
 	kerStartPos []int
@@ -86,7 +128,10 @@ type ConvPacker struct {
 	slipCnt     []int
 	slipCntSum  int
 	coreSizeSum int
+	fill        ConvKernalFill
+	fillVal     float64
 	info        ConvPackerCalInfo
+	gather      []int
 }
 
 func NewConvPacker(inputSize []int, param ConvKernalParam) *ConvPacker {
@@ -94,10 +139,14 @@ func NewConvPacker(inputSize []int, param ConvKernalParam) *ConvPacker {
 	inpCnt := inputSize[len(inputSize)-1]
 	ret := &ConvPacker{
 		orgSize:     inputSize,
-		coreSize:    append(param.size, inpCnt),
-		stride:      append(param.stride, inpCnt),
+		coreSize:    append(append([]int{}, param.size...), inpCnt),
+		stride:      append(append([]int{}, param.stride...), inpCnt),
 		paddingLeft: make([]int, dim),
 		fitSize:     make([]int, dim),
+		fill:        param.fill,
+	}
+	if param.fill == ConvKernalFillConst {
+		ret.fillVal = param.fillVal
 	}
 	if len(ret.coreSize) != dim {
 		panic(fmt.Sprintf("ConvPacker core size dims need equals to org:%d (%v) but %d (%v)", dim, inputSize, len(ret.coreSize), ret.coreSize))
@@ -105,6 +154,24 @@ func NewConvPacker(inputSize []int, param ConvKernalParam) *ConvPacker {
 	if len(ret.stride) != dim {
 		panic(fmt.Sprintf("ConvPacker stride dims need equals to org:%d (%v) but %d (%v)", dim, inputSize, len(ret.stride), ret.coreSize))
 	}
+	if param.pads != nil && len(param.pads) != dim-1 {
+		panic(fmt.Sprintf("ConvPacker pads dims need %d but %d (%v)", dim-1, len(param.pads), param.pads))
+	}
+	if param.dilation != nil && len(param.dilation) != dim-1 {
+		panic(fmt.Sprintf("ConvPacker dilation dims need %d but %d (%v)", dim-1, len(param.dilation), param.dilation))
+	}
+	// the packer walks the dilated span, the taps between are dropped from the gather table at last
+	taps := ret.coreSize
+	if param.dilation != nil {
+		ret.coreSize = make([]int, dim)
+		copy(ret.coreSize, taps)
+		for i, d := range param.dilation {
+			if d <= 0 {
+				panic(fmt.Sprintf("ConvPacker dilation need positive, but %v", param.dilation))
+			}
+			ret.coreSize[i] = (taps[i]-1)*d + 1
+		}
+	}
 	slips := make([]int, dim)
 	for i := 0; i < dim; i++ {
 		iinp := inputSize[i]
@@ -115,7 +182,19 @@ func NewConvPacker(inputSize []int, param ConvKernalParam) *ConvPacker {
 			slips[i] = 1
 			ret.fitSize[i] = inputSize[i]
 		} else {
-			pl, pr, slip := paddingCnt(iinp, icor, istr, param.padding)
+			var pl, pr, slip int
+			if param.pads != nil {
+				pl, pr = param.pads[i][0], param.pads[i][1]
+				slip = (iinp+pl+pr-icor)/istr + 1
+				if pl < 0 || pr < 0 || slip <= 0 {
+					panic(fmt.Sprintf("ConvPacker pads %v not fit size %v and core %v", param.pads, inputSize, ret.coreSize))
+				}
+			} else {
+				pl, pr, slip = paddingCnt(iinp, icor, istr, param.padding)
+			}
+			if param.fill == ConvKernalFillReflect && (pl >= iinp || pr >= iinp) {
+				panic(fmt.Sprintf("ConvPacker reflect padding (%d,%d) need smaller than size %d", pl, pr, iinp))
+			}
 			ret.paddingLeft[i] = pl
 			slips[i] = slip
 			ret.fitSize[i] = inputSize[i] + pl + pr
@@ -129,25 +208,69 @@ func NewConvPacker(inputSize []int, param ConvKernalParam) *ConvPacker {
 
 	step := dim - 2
 	ret.info = ConvPackerCalInfo{
-		needFit:     !common.IntsEqual(ret.orgSize, ret.fitSize),
-		fitStride:   common.IntsAddConst(1, make([]int, dim)),
 		kerStride:   common.IntsAddConst(1, make([]int, dim)),
-		pads:        make([]int, dim),
 		fitPos:      make([]int, dim),
-		orgPos:      make([]int, dim),
 		step:        step,
-		pad:         ret.paddingLeft[step],
-		padOffset:   common.IntsMin(ret.orgSize[step], ret.fitSize[step]) * inpCnt,
 		kerOffset:   ret.coreSize[step] * inpCnt,
 		kerCnt:      common.IntsAddConst(1, common.IntsSub(ret.fitSize, ret.coreSize)),
 		kerStartPos: make([]int, dim),
 	}
-	copy(ret.info.pads[:step], ret.paddingLeft[:step])
 	copy(ret.info.kerStride[step:], ret.coreSize[step:])
-	copy(ret.info.fitStride[step:], ret.orgSize[step:])
+	ret.gather = ret.gatherIdx()
+	if param.dilation != nil {
+		ret.dilate(taps, param.dilation)
+	}
 	return ret
 }
 
+// keeps the gather of the taps only, every dilation-th position of the span
+func (c *ConvPacker) dilate(taps, dilation []int) {
+	spanSum := c.coreSizeSum
+	var cols []int
+	common.RecuRange(c.coreSize, nil, func(pos []int) {
+		for i, d := range dilation {
+			if pos[i]%d != 0 {
+				return
+			}
+		}
+		cols = append(cols, common.PosIdx(pos, c.coreSize))
+	})
+	gather := make([]int, c.slipCntSum*len(cols))
+	for r := 0; r < c.slipCntSum; r++ {
+		row := c.gather[r*spanSum : (r+1)*spanSum]
+		for k, col := range cols {
+			gather[r*len(cols)+k] = row[col]
+		}
+	}
+	c.gather = gather
+	c.coreSize = taps
+	c.coreSizeSum = len(cols)
+}
+
+// the org index of every packed element, -1 for zero or constant padding
+func (c *ConvPacker) gatherIdx() []int {
+	// the padded input holds the org index + 1 of every position, 0 for none
+	idx := mat.NewVecDense(c.fitSizeSum, nil)
+	orgPos := make([]int, len(c.orgSize))
+	common.RecuRange(c.fitSize, nil, func(fitPos []int) {
+		for i, p := range fitPos {
+			orgPos[i] = c.fillPos(p-c.paddingLeft[i], c.orgSize[i])
+			if orgPos[i] < 0 {
+				return
+			}
+		}
+		idx.SetVec(common.PosIdx(fitPos, c.fitSize), float64(common.PosIdx(orgPos, c.orgSize)+1))
+	})
+	packed := mat.NewDense(c.slipCntSum, c.coreSizeSum, nil)
+	c.packSlow(packed, idx)
+	data := packed.RawMatrix().Data
+	gather := make([]int, len(data))
+	for k, v := range data {
+		gather[k] = int(v) - 1
+	}
+	return gather
+}
+
 func (c *ConvPacker) SlipCnt() ([]int, int) {
 	return c.slipCnt, c.slipCntSum
 }
@@ -204,26 +327,67 @@ func (c *ConvPacker) UnPack(vec *mat.Dense) *mat.VecDense {
 	return ret
 }
 
+// OutSize is the slip counts with chCnt channels
+func (c *ConvPacker) OutSize(chCnt int) []int {
+	out := append([]int{}, c.slipCnt[:len(c.slipCnt)-1]...)
+	return append(out, chCnt)
+}
+
 func (c *ConvPacker) PackTo(dst *mat.Dense, vec *mat.VecDense) {
-	step := c.info.step
-	fitVec := vec
-	if c.info.needFit {
-		fitVec = mat.NewVecDense(c.fitSizeSum, nil)
-		common.RecuRange(c.orgSize, c.info.fitStride, func(orgPos []int) {
-			common.IntsAddTo(c.info.fitPos, orgPos, c.info.pads)
-			if common.SizeBound(c.info.fitPos, c.fitSize) {
-				copy(c.info.orgPos, orgPos)
-				if c.info.pad < 0 {
-					c.info.orgPos[step] = -c.info.pad
-				} else {
-					c.info.fitPos[step] = c.info.pad
-				}
-				orgIdx := common.PosIdx(c.info.orgPos, c.orgSize)
-				fitIdx := common.PosIdx(c.info.fitPos, c.fitSize)
-				sliceVecCopy(fitVec, vec, fitIdx, orgIdx, c.info.padOffset)
+	raw := vec.RawVector()
+	for r := 0; r < c.slipCntSum; r++ {
+		row := dst.RawRowView(r)
+		gather := c.gather[r*c.coreSizeSum : (r+1)*c.coreSizeSum]
+		for k, idx := range gather {
+			if idx < 0 {
+				row[k] = c.fillVal
+				continue
 			}
-		})
+			row[k] = raw.Data[idx*raw.Inc]
+		}
+	}
+}
+
+// scatters the packed vec back, elements packed several times are summed
+func (c *ConvPacker) UnPackTo(dst *mat.VecDense, vec *mat.Dense) {
+	raw := dst.RawVector()
+	for i := 0; i < c.orgSizeSum; i++ {
+		raw.Data[i*raw.Inc] = 0
+	}
+	for r := 0; r < c.slipCntSum; r++ {
+		row := vec.RawRowView(r)
+		gather := c.gather[r*c.coreSizeSum : (r+1)*c.coreSizeSum]
+		for k, idx := range gather {
+			if idx >= 0 {
+				raw.Data[idx*raw.Inc] += row[k]
+			}
+		}
+	}
+}
+
+// the org position of a padded position p of a dim of size n, -1 for zero or constant padding
+func (c *ConvPacker) fillPos(p, n int) int {
+	if p >= 0 && p < n {
+		return p
+	}
+	switch c.fill {
+	case ConvKernalFillReflect:
+		if p < 0 {
+			return -p
+		}
+		return 2*(n-1) - p
+	case ConvKernalFillReplicate:
+		if p < 0 {
+			return 0
+		}
+		return n - 1
 	}
+	return -1
+}
+
+// packs the padded input by walking the kernels, only used to build the gather table
+func (c *ConvPacker) packSlow(dst *mat.Dense, fitVec *mat.VecDense) {
+	step := c.info.step
 
 	slipRowIdx := 0
 	common.RecuRange(c.info.kerCnt, c.stride, func(startPos []int) {
@@ -241,50 +405,36 @@ func (c *ConvPacker) PackTo(dst *mat.Dense, vec *mat.VecDense) {
 	})
 }
 
-func (c *ConvPacker) UnPackTo(dst *mat.VecDense, vec *mat.Dense) {
-	step := c.info.step
-	fitVec := dst
-	if c.info.needFit {
-		fitVec = mat.NewVecDense(c.fitSizeSum, nil)
+// runs do for every batch column, spread over the cpus
+func rangeBatches(batch int, do func(j int)) {
+	workers := runtime.NumCPU()
+	if workers > batch {
+		workers = batch
 	}
-	slipRowIdx := 0
-	common.RecuRange(c.info.kerCnt, c.stride, func(startPos []int) {
-		slipRow := vec.RowView(slipRowIdx).(*mat.VecDense)
-		slipRowIdx += 1
-		startOffset := startPos[step]
-		copy(c.info.kerStartPos[:step], startPos[:step])
-		common.RecuRange(c.coreSize, c.info.kerStride, func(corePos []int) {
-			common.IntsAddTo(c.info.fitPos, corePos, c.info.kerStartPos)
-			c.info.fitPos[step] = startOffset
-			fitIdx := common.PosIdx(c.info.fitPos, c.fitSize)
-			slipIdx := common.PosIdx(corePos, c.coreSize)
-			sliceVecAdd(fitVec, slipRow, fitIdx, slipIdx, c.info.kerOffset)
-		})
-	})
-
-	if c.info.needFit {
-		common.RecuRange(c.orgSize, c.info.fitStride, func(orgPos []int) {
-			common.IntsAddTo(c.info.fitPos, orgPos, c.info.pads)
-			if common.SizeBound(c.info.fitPos, c.fitSize) {
-				copy(c.info.orgPos, orgPos)
-				if c.info.pad < 0 {
-					c.info.orgPos[step] = -c.info.pad
-				} else {
-					c.info.fitPos[step] = c.info.pad
-				}
-				orgIdx := common.PosIdx(c.info.orgPos, c.orgSize)
-				fitIdx := common.PosIdx(c.info.fitPos, c.fitSize)
-				sliceVecCopy(dst, fitVec, orgIdx, fitIdx, c.info.padOffset)
+	if workers <= 1 {
+		for j := 0; j < batch; j++ {
+			do(j)
+		}
+		return
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for j := w; j < batch; j += workers {
+				do(j)
 			}
-		})
+		}(w)
 	}
+	wg.Wait()
 }
 
 func (c *ConvPacker) FoldBatches(org, fld *mat.Dense, cb func(dst *mat.Dense, src *mat.VecDense)) {
 	_, batch := org.Dims()
 	fldRow, fldCol := fld.Dims()
 	fldBatch := fldRow / batch
-	for j := 0; j < batch; j++ {
+	rangeBatches(batch, func(j int) {
 		sliceFld := fld.Slice(j*fldBatch, j*fldBatch+fldBatch, 0, fldCol).(*mat.Dense)
 		if cb == nil {
 			sliceFldData := sliceFld.RawMatrix().Data
@@ -293,14 +443,14 @@ func (c *ConvPacker) FoldBatches(org, fld *mat.Dense, cb func(dst *mat.Dense, sr
 		} else {
 			cb(sliceFld, org.ColView(j).(*mat.VecDense))
 		}
-	}
+	})
 }
 
 func (c *ConvPacker) UnfoldBatches(org, fld *mat.Dense, cb func(dst *mat.VecDense, src *mat.Dense)) {
 	_, batch := org.Dims()
 	fldRow, fldCol := fld.Dims()
 	fldBatch := fldRow / batch
-	for j := 0; j < batch; j++ {
+	rangeBatches(batch, func(j int) {
 		sliceFld := fld.Slice(j*fldBatch, j*fldBatch+fldBatch, 0, fldCol).(*mat.Dense)
 		if cb == nil {
 			org.SetCol(j, sliceFld.RawMatrix().Data)
@@ -308,7 +458,7 @@ func (c *ConvPacker) UnfoldBatches(org, fld *mat.Dense, cb func(dst *mat.VecDens
 			col := org.ColView(j).(*mat.VecDense)
 			cb(col, sliceFld)
 		}
-	}
+	})
 }
 
 type MatPicker struct {
@@ -330,6 +480,24 @@ func (m *MatPicker) SetMotion(dstDim, srcDim int) {
 	m.srcDim = srcDim
 }
 
+// Permute gives the row of the size of the picker moved to every row, dim i of the
+// permuted size is dim order[i] of the size
+func (m *MatPicker) Permute(order []int) []int {
+	out := make([]int, len(order))
+	for i, d := range order {
+		out[i] = m.size[d]
+	}
+	gather := make([]int, 0, common.IntsProd(m.size))
+	pos := make([]int, len(m.size))
+	common.RecuRange(out, nil, func(outPos []int) {
+		for i, d := range order {
+			pos[d] = outPos[i]
+		}
+		gather = append(gather, common.PosIdx(pos, m.size))
+	})
+	return gather
+}
+
 func (m *MatPicker) getter(data *mat.Dense) (gridData []float64, getter func(dst []float64, i int, a mat.Matrix) []float64) {
 	dr, dc := data.Dims()
 	switch m.srcDim {
@@ -75,6 +75,34 @@ func TestConvPacker2(t *testing.T) {
 	}
 }
 
+func TestConvPackerDilated(t *testing.T) {
+	packer := NewConvPacker([]int{4, 3, 1}, NewConvKParam([]int{2, 2}, []int{1, 1}, ConvKernalPadNo).Dilate([]int{2, 1}))
+	if slips, _ := packer.SlipCnt(); slips[0] != 2 || slips[1] != 2 {
+		t.Fatalf("dilated slips wrong: %v", slips)
+	}
+	data := mat.NewVecDense(12, []float64{
+		1, 2, 3,
+		4, 5, 6,
+		7, 8, 9,
+		10, 11, 12,
+	})
+	slip := packer.Pack(data)
+	tarSlip := mat.NewDense(4, 4, []float64{
+		1, 2, 7, 8,
+		2, 3, 8, 9,
+		4, 5, 10, 11,
+		5, 6, 11, 12,
+	})
+	if !mat.Equal(slip, tarSlip) {
+		t.Fatalf("dilated pack not right need:\n%v\nbut:\n%v\n", mat.Formatted(tarSlip), mat.Formatted(slip))
+	}
+	// all padding keeps the size with the dilated span
+	packer = NewConvPacker([]int{5, 5, 1}, NewConvKParam([]int{3, 3}, []int{1, 1}, ConvKernalPadAll).Dilate([]int{2, 2}))
+	if slips, _ := packer.SlipCnt(); slips[0] != 5 || slips[1] != 5 {
+		t.Fatalf("dilated all padding slips wrong: %v", slips)
+	}
+}
+
 func TestMatColPicker1(t *testing.T) {
 	picker := NewMatPicker(
 		[]int{2, 3, 2},
@@ -205,3 +233,82 @@ func TestMatColPicker3(t *testing.T) {
 		t.Fatalf("pick not right need:\n%v\nbut:\n%v\n", mat.Formatted(data), mat.Formatted(newData))
 	}
 }
+
+func TestConvPackerFill(t *testing.T) {
+	data := mat.NewVecDense(3, []float64{1, 2, 3})
+	param := NewConvKParam([]int{3}, []int{1}, ConvKernalPadNo).Pads([][2]int{{2, 2}})
+	cases := []struct {
+		name string
+		fill ConvKernalFill
+		need []float64
+	}{
+		{"zero", ConvKernalFillZero, []float64{0, 0, 1, 0, 1, 2, 1, 2, 3, 2, 3, 0, 3, 0, 0}},
+		{"const", ConvKernalFillConst, []float64{9, 9, 1, 9, 1, 2, 1, 2, 3, 2, 3, 9, 3, 9, 9}},
+		{"reflect", ConvKernalFillReflect, []float64{3, 2, 1, 2, 1, 2, 1, 2, 3, 2, 3, 2, 3, 2, 1}},
+		{"replicate", ConvKernalFillReplicate, []float64{1, 1, 1, 1, 1, 2, 1, 2, 3, 2, 3, 3, 3, 3, 3}},
+	}
+	for _, c := range cases {
+		packer := NewConvPacker([]int{3, 1}, param.Fill(c.fill, 9))
+		need := mat.NewDense(5, 3, c.need)
+		if slip := packer.Pack(data); !mat.Equal(need, slip) {
+			t.Fatalf("%s pack not right need:\n%v\nbut:\n%v\n", c.name, mat.Formatted(need), mat.Formatted(slip))
+		}
+	}
+}
+
+func TestConvPackerParamShared(t *testing.T) {
+	// spare capacity, so an append in place would write into the param
+	size := append(make([]int, 0, 3), 2, 2)
+	stride := append(make([]int, 0, 3), 1, 1)
+	param := NewConvKParam(size, stride, ConvKernalPadNo)
+	one := NewConvPacker([]int{4, 4, 1}, param)
+	NewConvPacker([]int{4, 4, 3}, param)
+	if one.coreSize[2] != 1 || one.stride[2] != 1 {
+		t.Fatalf("packer changed by another of the same param, core:%v stride:%v", one.coreSize, one.stride)
+	}
+}
+
+func TestConvPackerAdjoint(t *testing.T) {
+	packers := []*ConvPacker{
+		NewConvPacker([]int{5, 5, 2}, NewConvKParam([]int{3, 3}, []int{1, 1}, ConvKernalPadFit)),
+		NewConvPacker([]int{4, 4, 2}, NewConvKParam([]int{2, 2}, []int{2, 2}, ConvKernalPadNo)),
+		NewConvPacker([]int{7, 7, 3}, NewConvKParam([]int{3, 3}, []int{1, 1}, ConvKernalPadAll)),
+		NewConvPacker([]int{4, 5, 2}, NewConvKParam([]int{3, 3}, []int{1, 2}, ConvKernalPadNo).
+			Pads([][2]int{{2, 1}, {1, 3}}).Fill(ConvKernalFillReflect, 0)),
+		NewConvPacker([]int{4, 5, 2}, NewConvKParam([]int{3, 3}, []int{2, 1}, ConvKernalPadAll).Fill(ConvKernalFillReplicate, 0)),
+	}
+	for i, packer := range packers {
+		x := mat.NewVecDense(packer.orgSizeSum, nil)
+		for k := 0; k < x.Len(); k++ {
+			x.SetVec(k, float64(k%13)-6)
+		}
+		y := mat.NewDense(packer.slipCntSum, packer.coreSizeSum, nil)
+		y.Apply(func(r, c int, v float64) float64 {
+			return float64((r*7+c)%11) - 5
+		}, y)
+		// <pack(x), y> == <x, unpack(y)>
+		packed := packer.Pack(x)
+		packed.MulElem(packed, y)
+		packDot := mat.Sum(packed)
+		unpackDot := mat.Dot(x, packer.UnPack(y))
+		if packDot != unpackDot {
+			t.Fatalf("packer %d not adjoint, pack:%f, unpack:%f", i, packDot, unpackDot)
+		}
+	}
+}
+
+func BenchmarkHLayerConv(b *testing.B) {
+	layer := NewHLayerConv(NewConvKParam([]int{5, 5, 10}, []int{1, 1}, ConvKernalPadFit))
+	layer.InitSize([]int{28, 28, 1})
+	x := mat.NewDense(28*28, 16, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return float64((i*16+j)%7) / 7
+	}, x)
+	y := layer.Forward(x)
+	dy := mat.DenseCopyOf(y)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		layer.Forward(x)
+		layer.Backward(dy)
+	}
+}
@@ -1,52 +1,155 @@
 package cnn
 
 import (
-	"math/rand"
+	"fmt"
 	"pneuma/common"
 	"pneuma/nn"
 
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
 // w b均为展开状态，每列一个卷积核
+// grouped, w only has the rows of one group, the packed columns of group g are groupCols[g]
 type HLayerConv struct {
-	C     *ConvPacker
-	W     *mat.Dense
-	B     *mat.Dense
-	DW    *mat.Dense
-	DB    *mat.Dense
-	PackX *mat.Dense
-	param ConvKernalParam
+	C         *ConvPacker
+	W         *mat.Dense
+	B         *mat.Dense
+	DW        *mat.Dense
+	DB        *mat.Dense
+	PackX     *mat.Dense
+	GroupX    []*mat.Dense
+	param     ConvKernalParam
+	groupCols [][]int
+	initer    common.IInitializer
+	ws        *common.Workspace
 }
 
 func NewHLayerConv(param ConvKernalParam) *HLayerConv {
-	return &HLayerConv{param: param}
+	return &HLayerConv{param: param, initer: common.NewInitRand()}
+}
+
+func (l *HLayerConv) SetIniter(initer common.IInitializer) {
+	l.initer = initer
+}
+
+// nil before InitSize, the layer then runs on the whole batch
+func (l *HLayerConv) Replica() common.IHLayer {
+	if l.W == nil {
+		return nil
+	}
+	wr, wc := l.W.Dims()
+	br, bc := l.B.Dims()
+	return &HLayerConv{
+		C:         l.C,
+		W:         l.W,
+		B:         l.B,
+		DW:        mat.NewDense(wr, wc, nil),
+		DB:        mat.NewDense(br, bc, nil),
+		param:     l.param,
+		groupCols: l.groupCols,
+		initer:    l.initer,
+	}
+}
+
+func (l *HLayerConv) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
 }
 
 func (l *HLayerConv) InitSize(size []int) []int {
 	coreCnt := l.param.size[len(l.param.size)-1]
 	l.param.size = l.param.size[:len(l.param.size)-1]
 	l.C = NewConvPacker(size, l.param)
+	inptCnt := size[len(size)-1]
+	groups := l.Groups()
+	if inptCnt%groups != 0 || coreCnt%groups != 0 {
+		panic(fmt.Sprintf("conv channel count %d and kernel count %d not divisible by group count %d", inptCnt, coreCnt, groups))
+	}
+	wr := l.C.coreSizeSum / groups
+	if groups > 1 {
+		l.groupCols = make([][]int, groups)
+		chPGroup := inptCnt / groups
+		for k := 0; k < l.C.coreSizeSum; k++ {
+			g := k % inptCnt / chPGroup
+			l.groupCols[g] = append(l.groupCols[g], k)
+		}
+	}
 	l.B = mat.NewDense(l.C.slipCntSum, coreCnt, nil)
 	l.DB = mat.NewDense(l.C.slipCntSum, coreCnt, nil)
-	l.W = mat.NewDense(l.C.coreSizeSum, coreCnt, nil)
-	l.W.Apply(func(i, j int, v float64) float64 {
-		return rand.Float64() - 0.5
-	}, l.W)
-	l.DW = mat.NewDense(l.C.coreSizeSum, coreCnt, nil)
+	l.W = mat.NewDense(wr, coreCnt, nil)
+	// fan in is kernel size × input channels, fan out kernel size × kernel count, both of a group
+	l.initer.Init(l.W, wr, l.C.coreSizeSum/inptCnt*coreCnt/groups)
+	l.DW = mat.NewDense(wr, coreCnt, nil)
 	return append(l.C.slipCnt[:len(l.C.slipCnt)-1], coreCnt)
 }
 
+// Groups is the group count, 1 for a dense conv
+func (l *HLayerConv) Groups() int {
+	if l.param.groups <= 0 {
+		return 1
+	}
+	return l.param.groups
+}
+
+func (l *HLayerConv) Summary(size []int) (out []int, mulAdds int) {
+	wr, coreCnt := l.W.Dims()
+	return l.C.OutSize(coreCnt), l.C.slipCntSum * wr * coreCnt
+}
+
+// the group columns of packX times the group kernels
+func (l *HLayerConv) groupForward(packX, packY *mat.Dense) {
+	rows, _ := packX.Dims()
+	wr, wc := l.W.Dims()
+	kPGroup := wc / len(l.groupCols)
+	if len(l.GroupX) != len(l.groupCols) {
+		l.GroupX = make([]*mat.Dense, len(l.groupCols))
+	}
+	for g, cols := range l.groupCols {
+		gx := l.ws.Dense(rows, wr)
+		for i := 0; i < rows; i++ {
+			rowX, rowG := packX.RawRowView(i), gx.RawRowView(i)
+			for k, col := range cols {
+				rowG[k] = rowX[col]
+			}
+		}
+		l.GroupX[g] = gx
+		gy := packY.Slice(0, rows, g*kPGroup, (g+1)*kPGroup).(*mat.Dense)
+		gy.Mul(gx, l.W.Slice(0, wr, g*kPGroup, (g+1)*kPGroup))
+	}
+}
+
+func (l *HLayerConv) groupBackward(packDy, packDx *mat.Dense) {
+	rows, _ := packDy.Dims()
+	wr, wc := l.W.Dims()
+	kPGroup := wc / len(l.groupCols)
+	gdx := l.ws.Dense(rows, wr)
+	for g, cols := range l.groupCols {
+		gdy := packDy.Slice(0, rows, g*kPGroup, (g+1)*kPGroup)
+		dw := l.DW.Slice(0, wr, g*kPGroup, (g+1)*kPGroup).(*mat.Dense)
+		dw.Mul(l.GroupX[g].T(), gdy)
+		gdx.Mul(gdy, l.W.Slice(0, wr, g*kPGroup, (g+1)*kPGroup).T())
+		for i := 0; i < rows; i++ {
+			rowDx, rowG := packDx.RawRowView(i), gdx.RawRowView(i)
+			for k, col := range cols {
+				rowDx[col] = rowG[k]
+			}
+		}
+	}
+}
+
 func (l *HLayerConv) Forward(x *mat.Dense) (y *mat.Dense) {
 	batch := x.RawMatrix().Cols
-	wr, _ := l.W.Dims()
 	br, bc := l.B.Dims()
-	packX := mat.NewDense(br*batch, wr, nil)
+	packX := l.ws.Dense(br*batch, l.C.coreSizeSum)
 	l.C.FoldBatches(x, packX, l.C.PackTo)
-	packY := mat.NewDense(br*batch, bc, nil)
+	packY := l.ws.Dense(br*batch, bc)
 	l.PackX = packX
 
-	packY.Mul(packX, l.W)
+	if l.groupCols != nil {
+		l.groupForward(packX, packY)
+	} else {
+		packY.Mul(packX, l.W)
+	}
 	blen := br * bc
 	packYData := packY.RawMatrix().Data
 	for j := 0; j < len(packYData); j += blen {
@@ -54,31 +157,34 @@ func (l *HLayerConv) Forward(x *mat.Dense) (y *mat.Dense) {
 		sliceY.Add(sliceY, l.B)
 	}
 
-	y = mat.NewDense(br*bc, batch, nil)
+	y = l.ws.Dense(br*bc, batch)
 	l.C.UnfoldBatches(y, packY, nil)
 	return
 }
 
 func (l *HLayerConv) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	batch := dy.RawMatrix().Cols
-	wr, _ := l.W.Dims()
 	br, bc := l.B.Dims()
 	l.DW.Zero()
 	l.DB.Zero()
-	packDy := mat.NewDense(br*batch, bc, nil)
+	packDy := l.ws.Dense(br*batch, bc)
 	l.C.FoldBatches(dy, packDy, nil)
-	packDx := mat.NewDense(br*batch, wr, nil)
+	packDx := l.ws.Dense(br*batch, l.C.coreSizeSum)
 
-	l.DW.Mul(l.PackX.T(), packDy)
 	blen := br * bc
 	packDyData := packDy.RawMatrix().Data
 	for j := 0; j < len(packDyData); j += blen {
 		sliceDy := mat.NewDense(br, bc, packDyData[j:j+blen])
 		l.DB.Add(l.DB, sliceDy)
 	}
-	packDx.Mul(packDy, l.W.T())
+	if l.groupCols != nil {
+		l.groupBackward(packDy, packDx)
+	} else {
+		l.DW.Mul(l.PackX.T(), packDy)
+		packDx.Mul(packDy, l.W.T())
+	}
 
-	dx = mat.NewDense(l.C.orgSizeSum, batch, nil)
+	dx = l.ws.Dense(l.C.orgSizeSum, batch)
 	l.C.UnfoldBatches(dx, packDx, l.C.UnPackTo)
 	return
 }
@@ -93,6 +199,210 @@ func (l *HLayerConv) Optimize() (datas, deltas []mat.Matrix) {
 	return
 }
 
+// a depthwise conv of one kernel per channel, then a pointwise 1×1 conv mixing the channels,
+// the param is of the depthwise kernel, its last size is the kernel count of the pointwise one
+type HLayerSeparableConv struct {
+	Depthwise *HLayerConv
+	Pointwise *HLayerConv
+	param     ConvKernalParam
+	initer    common.IInitializer
+	ws        *common.Workspace
+}
+
+func NewHLayerSeparableConv(param ConvKernalParam) *HLayerSeparableConv {
+	return &HLayerSeparableConv{param: param, initer: common.NewInitRand()}
+}
+
+func (l *HLayerSeparableConv) SetIniter(initer common.IInitializer) {
+	l.initer = initer
+}
+
+func (l *HLayerSeparableConv) Replica() common.IHLayer {
+	return &HLayerSeparableConv{
+		Depthwise: l.Depthwise.Replica().(*HLayerConv),
+		Pointwise: l.Pointwise.Replica().(*HLayerConv),
+		param:     l.param,
+		initer:    l.initer,
+	}
+}
+
+// the convs are made at InitSize, they take the workspace then if not yet
+func (l *HLayerSeparableConv) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+	if l.Depthwise != nil {
+		l.Depthwise.SetWorkspace(ws)
+		l.Pointwise.SetWorkspace(ws)
+	}
+}
+
+func (l *HLayerSeparableConv) InitSize(size []int) []int {
+	dim := len(l.param.size) - 1
+	chCnt := size[len(size)-1]
+	depth := l.param
+	depth.size = append(append([]int{}, l.param.size[:dim]...), chCnt)
+	l.Depthwise = NewHLayerConv(depth.Group(chCnt))
+	l.Depthwise.SetIniter(l.initer)
+	ones := common.IntsAddConst(1, make([]int, dim))
+	l.Pointwise = NewHLayerConv(NewConvKParam(append(append([]int{}, ones...), l.param.size[dim]), ones, ConvKernalPadNo))
+	l.Pointwise.SetIniter(l.initer)
+	l.SetWorkspace(l.ws)
+	return l.Pointwise.InitSize(l.Depthwise.InitSize(size))
+}
+
+func (l *HLayerSeparableConv) Summary(size []int) (out []int, mulAdds int) {
+	mid, depthMulAdds := l.Depthwise.Summary(size)
+	out, mulAdds = l.Pointwise.Summary(mid)
+	return out, depthMulAdds + mulAdds
+}
+
+func (l *HLayerSeparableConv) Forward(x *mat.Dense) (y *mat.Dense) {
+	return l.Pointwise.Forward(l.Depthwise.Forward(x))
+}
+
+func (l *HLayerSeparableConv) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	return l.Depthwise.Backward(l.Pointwise.Backward(dy))
+}
+
+func (l *HLayerSeparableConv) Optimize() (datas, deltas []mat.Matrix) {
+	return common.OptimizeData(l.Depthwise, l.Pointwise)
+}
+
+// the adjoint of HLayerConv, its packer runs over the output, so the forward unpacks
+// and the backward packs, w has a row for every packed output element and a column
+// for every input channel, b a row for every output element
+type HLayerConvTranspose struct {
+	C      *ConvPacker
+	W      *mat.Dense
+	B      *mat.Dense
+	DW     *mat.Dense
+	DB     *mat.Dense
+	FoldX  *mat.Dense
+	param  ConvKernalParam
+	inCnt  int
+	initer common.IInitializer
+	ws     *common.Workspace
+}
+
+func NewHLayerConvTranspose(param ConvKernalParam) *HLayerConvTranspose {
+	return &HLayerConvTranspose{param: param, initer: common.NewInitRand()}
+}
+
+func (l *HLayerConvTranspose) SetIniter(initer common.IInitializer) {
+	l.initer = initer
+}
+
+// nil before InitSize, the layer then runs on the whole batch
+func (l *HLayerConvTranspose) Replica() common.IHLayer {
+	if l.W == nil {
+		return nil
+	}
+	wr, wc := l.W.Dims()
+	br, bc := l.B.Dims()
+	return &HLayerConvTranspose{
+		C:      l.C,
+		W:      l.W,
+		B:      l.B,
+		DW:     mat.NewDense(wr, wc, nil),
+		DB:     mat.NewDense(br, bc, nil),
+		param:  l.param,
+		inCnt:  l.inCnt,
+		initer: l.initer,
+	}
+}
+
+func (l *HLayerConvTranspose) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+// the output is the smallest size the conv of the same param slips back to the input,
+// (in-1)*stride+span less the explicit pads, or in*stride with all padding
+func (l *HLayerConvTranspose) InitSize(size []int) []int {
+	if l.param.groups > 1 {
+		panic(fmt.Sprintf("conv transpose not supports groups, but %d", l.param.groups))
+	}
+	dim := len(size) - 1
+	coreCnt := l.param.size[len(l.param.size)-1]
+	l.param.size = l.param.size[:len(l.param.size)-1]
+	out := make([]int, dim+1)
+	for i := 0; i < dim; i++ {
+		span := l.param.size[i]
+		if l.param.dilation != nil {
+			span = (span-1)*l.param.dilation[i] + 1
+		}
+		out[i] = (size[i]-1)*l.param.stride[i] + span
+		if l.param.pads != nil {
+			out[i] -= l.param.pads[i][0] + l.param.pads[i][1]
+		} else if l.param.padding == ConvKernalPadAll {
+			out[i] = size[i] * l.param.stride[i]
+		}
+	}
+	out[dim] = coreCnt
+	// a constant fill is no input of the adjoint, the backward packs dy with zeros there
+	param := l.param
+	if param.fill == ConvKernalFillConst {
+		param = param.Fill(ConvKernalFillZero, 0)
+	}
+	l.C = NewConvPacker(out, param)
+	if !common.IntsEqual(l.C.slipCnt[:dim], size[:dim]) {
+		panic(fmt.Sprintf("conv transpose output %v not slips back to input %v but %v", out, size, l.C.slipCnt))
+	}
+	l.inCnt = size[dim]
+	l.W = mat.NewDense(l.C.coreSizeSum, l.inCnt, nil)
+	l.DW = mat.NewDense(l.C.coreSizeSum, l.inCnt, nil)
+	l.B = mat.NewDense(l.C.orgSizeSum, 1, nil)
+	l.DB = mat.NewDense(l.C.orgSizeSum, 1, nil)
+	// fan in is kernel size × input channels, fan out kernel size × kernel count
+	l.initer.Init(l.W, l.C.coreSizeSum/coreCnt*l.inCnt, l.C.coreSizeSum)
+	return out
+}
+
+func (l *HLayerConvTranspose) Summary(size []int) (out []int, mulAdds int) {
+	return l.C.orgSize, l.C.slipCntSum * l.C.coreSizeSum * l.inCnt
+}
+
+func (l *HLayerConvTranspose) Forward(x *mat.Dense) (y *mat.Dense) {
+	batch := x.RawMatrix().Cols
+	foldX := l.ws.Dense(l.C.slipCntSum*batch, l.inCnt)
+	l.C.FoldBatches(x, foldX, nil)
+	l.FoldX = foldX
+	packY := l.ws.Dense(l.C.slipCntSum*batch, l.C.coreSizeSum)
+	packY.Mul(foldX, l.W.T())
+	y = l.ws.Dense(l.C.orgSizeSum, batch)
+	l.C.UnfoldBatches(y, packY, l.C.UnPackTo)
+	for j := 0; j < batch; j++ {
+		col := y.ColView(j).(*mat.VecDense)
+		col.AddVec(col, l.B.ColView(0))
+	}
+	return
+}
+
+func (l *HLayerConvTranspose) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	batch := dy.RawMatrix().Cols
+	l.DB.Zero()
+	dbCol := l.DB.ColView(0).(*mat.VecDense)
+	for j := 0; j < batch; j++ {
+		dbCol.AddVec(dbCol, dy.ColView(j))
+	}
+	packDy := l.ws.Dense(l.C.slipCntSum*batch, l.C.coreSizeSum)
+	l.C.FoldBatches(dy, packDy, l.C.PackTo)
+	l.DW.Mul(packDy.T(), l.FoldX)
+	foldDx := l.ws.Dense(l.C.slipCntSum*batch, l.inCnt)
+	foldDx.Mul(packDy, l.W)
+	dx = l.ws.Dense(l.C.slipCntSum*l.inCnt, batch)
+	l.C.UnfoldBatches(dx, foldDx, nil)
+	return
+}
+
+func (l *HLayerConvTranspose) Optimize() (datas, deltas []mat.Matrix) {
+	datas = []mat.Matrix{
+		l.W, l.B,
+	}
+	deltas = []mat.Matrix{
+		l.DW, l.DB,
+	}
+	return
+}
+
 type HLayerDimBatchNorm struct {
 	*nn.HLayerBatchNorm
 	picker   *MatPicker
@@ -138,6 +448,53 @@ func (l *HLayerDimBatchNorm) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	return l.picker.Pick(newDx)
 }
 
+// drops whole channels, channels are the trailing dim of each column
+type HLayerSpatialDropout struct {
+	*nn.HLayerDropout
+	chCnt int
+	ws    *common.Workspace
+}
+
+func NewHLayerSpatialDropout(rate float64, seed int64) *HLayerSpatialDropout {
+	return &HLayerSpatialDropout{
+		HLayerDropout: nn.NewHLayerDropout(rate, seed),
+	}
+}
+
+func (l *HLayerSpatialDropout) Replica() common.IHLayer {
+	return &HLayerSpatialDropout{
+		HLayerDropout: l.HLayerDropout.Replica().(*nn.HLayerDropout),
+		chCnt:         l.chCnt,
+	}
+}
+
+func (l *HLayerSpatialDropout) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+	l.HLayerDropout.SetWorkspace(ws)
+}
+
+func (l *HLayerSpatialDropout) InitSize(size []int) []int {
+	l.chCnt = size[len(size)-1]
+	return size
+}
+
+func (l *HLayerSpatialDropout) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	keeps := make([]float64, l.chCnt)
+	l.Mask = l.ws.Dense(r, c)
+	for j := 0; j < c; j++ {
+		for k := 0; k < l.chCnt; k++ {
+			keeps[k] = l.Keep()
+		}
+		for i := 0; i < r; i++ {
+			l.Mask.Set(i, j, keeps[i%l.chCnt])
+		}
+	}
+	y = l.ws.Dense(r, c)
+	y.MulElem(x, l.Mask)
+	return
+}
+
 type MaxPoolingCalInfo struct {
 	search []int
 	idxes  []int
@@ -150,12 +507,30 @@ type HLayerMaxPooling struct {
 	inptSize []int
 	param    ConvKernalParam
 	info     MaxPoolingCalInfo
+	ws       *common.Workspace
 }
 
 func NewHLayerMaxPooling(param ConvKernalParam) *HLayerMaxPooling {
 	return &HLayerMaxPooling{param: param}
 }
 
+func (l *HLayerMaxPooling) Replica() common.IHLayer {
+	return &HLayerMaxPooling{
+		C:        l.C,
+		inptSize: l.inptSize,
+		param:    l.param,
+		info: MaxPoolingCalInfo{
+			search: l.info.search,
+			idxes:  make([]int, len(l.info.idxes)),
+			cnt:    l.info.cnt,
+		},
+	}
+}
+
+func (l *HLayerMaxPooling) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
 func (l *HLayerMaxPooling) InitSize(size []int) []int {
 	inptCnt := size[len(size)-1]
 	l.C = NewConvPacker(size, l.param)
@@ -169,10 +544,15 @@ func (l *HLayerMaxPooling) InitSize(size []int) []int {
 	return append(l.C.slipCnt[:len(l.C.slipCnt)-1], inptCnt)
 }
 
+// a comparison for every element of every window
+func (l *HLayerMaxPooling) Summary(size []int) (out []int, mulAdds int) {
+	return l.C.OutSize(l.info.cnt), l.C.slipCntSum * l.C.coreSizeSum
+}
+
 func (l *HLayerMaxPooling) Forward(x *mat.Dense) (y *mat.Dense) {
 	batch := x.RawMatrix().Cols
-	packX := mat.NewDense(l.C.slipCntSum*batch, l.C.coreSizeSum, nil)
-	packY := mat.NewDense(l.C.slipCntSum*batch, l.info.cnt, nil)
+	packX := l.ws.Dense(l.C.slipCntSum*batch, l.C.coreSizeSum)
+	packY := l.ws.Dense(l.C.slipCntSum*batch, l.info.cnt)
 	l.C.FoldBatches(x, packX, l.C.PackTo)
 	l.PackX = packX
 	for i := 0; i < l.C.slipCntSum*batch; i++ {
@@ -192,14 +572,14 @@ func (l *HLayerMaxPooling) Forward(x *mat.Dense) (y *mat.Dense) {
 			rowX[l.info.idxes[k]] = 1
 		}
 	}
-	y = mat.NewDense(l.C.slipCntSum*l.info.cnt, batch, nil)
+	y = l.ws.Dense(l.C.slipCntSum*l.info.cnt, batch)
 	l.C.UnfoldBatches(y, packY, nil)
 	return
 }
 
 func (l *HLayerMaxPooling) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	batch := dy.RawMatrix().Cols
-	packDy := mat.NewDense(l.C.slipCntSum*batch, l.info.cnt, nil)
+	packDy := l.ws.Dense(l.C.slipCntSum*batch, l.info.cnt)
 	packDx := l.PackX
 	l.C.FoldBatches(dy, packDy, nil)
 	for j := 0; j < l.C.coreSizeSum; j++ {
@@ -207,7 +587,363 @@ func (l *HLayerMaxPooling) Backward(dy *mat.Dense) (dx *mat.Dense) {
 		colDx := packDx.ColView(j).(*mat.VecDense)
 		colDx.MulElemVec(colDx, colDy)
 	}
-	dx = mat.NewDense(l.C.orgSizeSum, batch, nil)
+	dx = l.ws.Dense(l.C.orgSizeSum, batch)
 	l.C.UnfoldBatches(dx, packDx, l.C.UnPackTo)
 	return
 }
+
+// averages every window, padding counts as zeros
+type HLayerAvgPooling struct {
+	C     *ConvPacker
+	param ConvKernalParam
+	span  int
+	cnt   int
+	ws    *common.Workspace
+}
+
+func NewHLayerAvgPooling(param ConvKernalParam) *HLayerAvgPooling {
+	return &HLayerAvgPooling{param: param}
+}
+
+func (l *HLayerAvgPooling) Replica() common.IHLayer {
+	return &HLayerAvgPooling{
+		C:     l.C,
+		param: l.param,
+		span:  l.span,
+		cnt:   l.cnt,
+	}
+}
+
+func (l *HLayerAvgPooling) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerAvgPooling) InitSize(size []int) []int {
+	l.cnt = size[len(size)-1]
+	l.C = NewConvPacker(size, l.param)
+	l.span = l.C.coreSizeSum / l.cnt
+	return l.C.OutSize(l.cnt)
+}
+
+func (l *HLayerAvgPooling) Summary(size []int) (out []int, mulAdds int) {
+	return l.C.OutSize(l.cnt), l.C.slipCntSum * l.C.coreSizeSum
+}
+
+func (l *HLayerAvgPooling) Forward(x *mat.Dense) (y *mat.Dense) {
+	batch := x.RawMatrix().Cols
+	packX := l.ws.Dense(l.C.slipCntSum*batch, l.C.coreSizeSum)
+	packY := l.ws.Dense(l.C.slipCntSum*batch, l.cnt)
+	l.C.FoldBatches(x, packX, l.C.PackTo)
+	scale := 1 / float64(l.span)
+	for i := 0; i < l.C.slipCntSum*batch; i++ {
+		rowX := packX.RawRowView(i)
+		rowY := packY.RawRowView(i)
+		for idx, v := range rowX {
+			rowY[idx%l.cnt] += v * scale
+		}
+	}
+	y = l.ws.Dense(l.C.slipCntSum*l.cnt, batch)
+	l.C.UnfoldBatches(y, packY, nil)
+	return
+}
+
+func (l *HLayerAvgPooling) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	batch := dy.RawMatrix().Cols
+	packDy := l.ws.Dense(l.C.slipCntSum*batch, l.cnt)
+	packDx := l.ws.Dense(l.C.slipCntSum*batch, l.C.coreSizeSum)
+	l.C.FoldBatches(dy, packDy, nil)
+	scale := 1 / float64(l.span)
+	for i := 0; i < l.C.slipCntSum*batch; i++ {
+		rowDy := packDy.RawRowView(i)
+		rowDx := packDx.RawRowView(i)
+		for idx := range rowDx {
+			rowDx[idx] = rowDy[idx%l.cnt] * scale
+		}
+	}
+	dx = l.ws.Dense(l.C.orgSizeSum, batch)
+	l.C.UnfoldBatches(dx, packDx, l.C.UnPackTo)
+	return
+}
+
+// averages every channel over all the spatial positions, the output size is the channels
+type HLayerGlobalAvgPooling struct {
+	cnt  int
+	span int
+	ws   *common.Workspace
+}
+
+func NewHLayerGlobalAvgPooling() *HLayerGlobalAvgPooling {
+	return &HLayerGlobalAvgPooling{}
+}
+
+func (l *HLayerGlobalAvgPooling) Replica() common.IHLayer {
+	return &HLayerGlobalAvgPooling{cnt: l.cnt, span: l.span}
+}
+
+func (l *HLayerGlobalAvgPooling) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerGlobalAvgPooling) InitSize(size []int) []int {
+	l.cnt = size[len(size)-1]
+	l.span = common.IntsProd(size) / l.cnt
+	return []int{l.cnt}
+}
+
+func (l *HLayerGlobalAvgPooling) Summary(size []int) (out []int, mulAdds int) {
+	return []int{l.cnt}, l.span * l.cnt
+}
+
+func (l *HLayerGlobalAvgPooling) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(l.cnt, c)
+	scale := 1 / float64(l.span)
+	for i := 0; i < r; i++ {
+		rowX := x.RawRowView(i)
+		rowY := y.RawRowView(i % l.cnt)
+		for j, v := range rowX {
+			rowY[j] += v * scale
+		}
+	}
+	return
+}
+
+func (l *HLayerGlobalAvgPooling) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	_, c := dy.Dims()
+	dx = l.ws.Dense(l.span*l.cnt, c)
+	scale := 1 / float64(l.span)
+	for i := 0; i < l.span*l.cnt; i++ {
+		rowDx := dx.RawRowView(i)
+		rowDy := dy.RawRowView(i % l.cnt)
+		for j, v := range rowDy {
+			rowDx[j] = v * scale
+		}
+	}
+	return
+}
+
+// takes the max of every channel over all the spatial positions, the output size is the channels
+type HLayerGlobalMaxPooling struct {
+	cnt   int
+	span  int
+	idxes []int
+	ws    *common.Workspace
+}
+
+func NewHLayerGlobalMaxPooling() *HLayerGlobalMaxPooling {
+	return &HLayerGlobalMaxPooling{}
+}
+
+func (l *HLayerGlobalMaxPooling) Replica() common.IHLayer {
+	return &HLayerGlobalMaxPooling{cnt: l.cnt, span: l.span}
+}
+
+func (l *HLayerGlobalMaxPooling) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerGlobalMaxPooling) InitSize(size []int) []int {
+	l.cnt = size[len(size)-1]
+	l.span = common.IntsProd(size) / l.cnt
+	return []int{l.cnt}
+}
+
+func (l *HLayerGlobalMaxPooling) Summary(size []int) (out []int, mulAdds int) {
+	return []int{l.cnt}, l.span * l.cnt
+}
+
+// idxes keeps the row of the max for every output element
+func (l *HLayerGlobalMaxPooling) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(l.cnt, c)
+	if len(l.idxes) != l.cnt*c {
+		l.idxes = make([]int, l.cnt*c)
+	}
+	for k := 0; k < l.cnt; k++ {
+		rowY := y.RawRowView(k)
+		idxes := l.idxes[k*c : k*c+c]
+		copy(rowY, x.RawRowView(k))
+		common.IntsSetConst(k, idxes)
+		for i := k + l.cnt; i < r; i += l.cnt {
+			for j, v := range x.RawRowView(i) {
+				if v > rowY[j] {
+					rowY[j] = v
+					idxes[j] = i
+				}
+			}
+		}
+	}
+	return
+}
+
+func (l *HLayerGlobalMaxPooling) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	_, c := dy.Dims()
+	dx = l.ws.Dense(l.span*l.cnt, c)
+	for k := 0; k < l.cnt; k++ {
+		for j, v := range dy.RawRowView(k) {
+			dx.Set(l.idxes[k*c+j], j, v)
+		}
+	}
+	return
+}
+
+type upsampleTap struct {
+	idx    int
+	weight float64
+}
+
+// enlarges every spatial dim by its scale, nearest copies the covering input,
+// bilinear interpolates the nearest inputs by the centers, clamped at the edges
+type HLayerUpsampling struct {
+	scale    []int
+	bilinear bool
+	inSize   []int
+	outSize  []int
+	taps     [][]upsampleTap
+	ws       *common.Workspace
+}
+
+func NewHLayerUpsamplingNearest(scale []int) *HLayerUpsampling {
+	return &HLayerUpsampling{scale: scale}
+}
+
+func NewHLayerUpsamplingBilinear(scale []int) *HLayerUpsampling {
+	return &HLayerUpsampling{scale: scale, bilinear: true}
+}
+
+func (l *HLayerUpsampling) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerUpsampling) InitSize(size []int) []int {
+	dim := len(size) - 1
+	if len(l.scale) != dim {
+		panic(fmt.Sprintf("upsampling scale dims need %d but %d (%v)", dim, len(l.scale), l.scale))
+	}
+	l.inSize = size[:dim]
+	l.outSize = make([]int, dim)
+	dimTaps := make([][][]upsampleTap, dim)
+	for i := 0; i < dim; i++ {
+		l.outSize[i] = size[i] * l.scale[i]
+		dimTaps[i] = make([][]upsampleTap, l.outSize[i])
+		for p := range dimTaps[i] {
+			dimTaps[i][p] = l.dimTaps(p, size[i], l.scale[i])
+		}
+	}
+	// every output position takes the products of the taps of its dims
+	l.taps = make([][]upsampleTap, 0, common.IntsProd(l.outSize))
+	common.RecuRange(l.outSize, nil, func(pos []int) {
+		taps := []upsampleTap{{0, 1}}
+		for i, p := range pos {
+			next := make([]upsampleTap, 0, len(taps)*2)
+			for _, t := range taps {
+				for _, dt := range dimTaps[i][p] {
+					next = append(next, upsampleTap{common.IdxExpend(t.idx, dt.idx, l.inSize[i]), t.weight * dt.weight})
+				}
+			}
+			taps = next
+		}
+		l.taps = append(l.taps, taps)
+	})
+	return append(append([]int{}, l.outSize...), size[dim])
+}
+
+func (l *HLayerUpsampling) dimTaps(p, size, scale int) []upsampleTap {
+	if !l.bilinear {
+		return []upsampleTap{{p / scale, 1}}
+	}
+	src := (float64(p)+0.5)/float64(scale) - 0.5
+	if src <= 0 {
+		return []upsampleTap{{0, 1}}
+	}
+	if src >= float64(size-1) {
+		return []upsampleTap{{size - 1, 1}}
+	}
+	lo := int(src)
+	frac := src - float64(lo)
+	return []upsampleTap{{lo, 1 - frac}, {lo + 1, frac}}
+}
+
+func (l *HLayerUpsampling) Summary(size []int) (out []int, mulAdds int) {
+	chCnt := size[len(size)-1]
+	for _, taps := range l.taps {
+		mulAdds += len(taps) * chCnt
+	}
+	return append(append([]int{}, l.outSize...), chCnt), mulAdds
+}
+
+func (l *HLayerUpsampling) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	chCnt := r / common.IntsProd(l.inSize)
+	y = l.ws.Dense(len(l.taps)*chCnt, c)
+	for o, taps := range l.taps {
+		for k := 0; k < chCnt; k++ {
+			rowY := y.RawRowView(o*chCnt + k)
+			for _, t := range taps {
+				floats.AddScaled(rowY, t.weight, x.RawRowView(t.idx*chCnt+k))
+			}
+		}
+	}
+	return
+}
+
+func (l *HLayerUpsampling) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	chCnt := r / len(l.taps)
+	dx = l.ws.Dense(common.IntsProd(l.inSize)*chCnt, c)
+	for o, taps := range l.taps {
+		for k := 0; k < chCnt; k++ {
+			rowDy := dy.RawRowView(o*chCnt + k)
+			for _, t := range taps {
+				floats.AddScaled(dx.RawRowView(t.idx*chCnt+k), t.weight, rowDy)
+			}
+		}
+	}
+	return
+}
+
+// normalizes each sample within groups of channels, channels are the trailing dim
+type HLayerGroupNorm struct {
+	*nn.HLayerNorm
+	groupCnt int
+}
+
+func NewHLayerGroupNorm(groupCnt int, minstd float64) *HLayerGroupNorm {
+	return &HLayerGroupNorm{
+		HLayerNorm: nn.NewHLayerNorm(minstd),
+		groupCnt:   groupCnt,
+	}
+}
+
+// group norm with one group per channel
+func NewHLayerInstanceNorm(minstd float64) *HLayerGroupNorm {
+	return NewHLayerGroupNorm(0, minstd)
+}
+
+func (l *HLayerGroupNorm) Replica() common.IHLayer {
+	return &HLayerGroupNorm{
+		HLayerNorm: l.HLayerNorm.Replica().(*nn.HLayerNorm),
+		groupCnt:   l.groupCnt,
+	}
+}
+
+func (l *HLayerGroupNorm) InitSize(size []int) []int {
+	chCnt := size[len(size)-1]
+	groupCnt := l.groupCnt
+	if groupCnt <= 0 {
+		groupCnt = chCnt
+	}
+	if chCnt%groupCnt != 0 {
+		panic(fmt.Sprintf("channel count %d not divisible by group count %d", chCnt, groupCnt))
+	}
+	chPGroup := chCnt / groupCnt
+	r := common.IntsProd(size)
+	groups := make([]int, r)
+	params := make([]int, r)
+	for i := 0; i < r; i++ {
+		params[i] = i % chCnt
+		groups[i] = params[i] / chPGroup
+	}
+	l.SetGroups(groups, params)
+	return size
+}
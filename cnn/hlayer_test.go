@@ -1,6 +1,11 @@
 package cnn
 
 import (
+	"bytes"
+	"math"
+	"pneuma/common"
+	"pneuma/nn"
+	"strings"
 	"testing"
 
 	"gonum.org/v1/gonum/mat"
@@ -8,11 +13,11 @@ import (
 
 func TestHLayerConv(t *testing.T) {
 	layer := NewHLayerConv(
-		ConvKernalParam{
+		NewConvKParam(
 			[]int{2, 2, 3},
 			[]int{2, 2},
 			ConvKernalPadFit,
-		},
+		),
 	)
 	layer.InitSize([]int{4, 3, 2})
 	data := mat.NewVecDense(24, []float64{
@@ -36,11 +41,11 @@ func TestHLayerConv(t *testing.T) {
 }
 func TestHLayerConv2(t *testing.T) {
 	layer := NewHLayerConv(
-		ConvKernalParam{
+		NewConvKParam(
 			[]int{3, 3, 1},
 			[]int{1, 1},
 			ConvKernalPadAll,
-		},
+		),
 	)
 	layer.InitSize([]int{2, 2, 1})
 	data := mat.NewVecDense(4, []float64{
@@ -70,11 +75,11 @@ func TestHLayerConv2(t *testing.T) {
 }
 func TestHLayerMaxPooling(t *testing.T) {
 	layer := NewHLayerMaxPooling(
-		ConvKernalParam{
+		NewConvKParam(
 			[]int{2, 2},
 			[]int{2, 2},
 			ConvKernalPadFit,
-		},
+		),
 	)
 	layer.InitSize([]int{4, 3, 2})
 	x := mat.NewDense(24, 1, []float64{
@@ -106,3 +111,289 @@ func TestHLayerMaxPooling(t *testing.T) {
 		t.Fatalf("maxpooling backword wrong need:\n%v\nbut:\n%v\n", dxtar, dx)
 	}
 }
+
+func TestHLayerConvGrouped(t *testing.T) {
+	// a grouped conv is the dense conv with the kernels zeroed out of their group
+	grouped := NewHLayerConv(NewConvKParam([]int{2, 2, 2}, []int{1, 1}, ConvKernalPadNo).Group(2))
+	dense := NewHLayerConv(NewConvKParam([]int{2, 2, 2}, []int{1, 1}, ConvKernalPadNo))
+	grouped.InitSize([]int{3, 3, 2})
+	dense.InitSize([]int{3, 3, 2})
+	if r, c := grouped.W.Dims(); r != 4 || c != 2 {
+		t.Fatalf("grouped w dims need (4,2), but (%d,%d)", r, c)
+	}
+	dense.W.Zero()
+	for g, cols := range grouped.groupCols {
+		for k, col := range cols {
+			dense.W.Set(col, g, grouped.W.At(k, g))
+		}
+	}
+	x := mat.NewDense(18, 2, nil)
+	x.Apply(func(i, j int, v float64) float64 { return float64((i*5+j*3)%7) / 7 }, x)
+	if need, y := dense.Forward(x), grouped.Forward(x); !mat.EqualApprox(need, y, 1e-12) {
+		t.Fatalf("grouped forward wrong need:\n%v\nbut:\n%v\n", mat.Formatted(need), mat.Formatted(y))
+	}
+	dy := mat.NewDense(8, 2, []float64{1, 2, 3, 4, 5, 6, 7, 8, 8, 7, 6, 5, 4, 3, 2, 1})
+	if need, dx := dense.Backward(dy), grouped.Backward(dy); !mat.EqualApprox(need, dx, 1e-12) {
+		t.Fatalf("grouped backward wrong need:\n%v\nbut:\n%v\n", mat.Formatted(need), mat.Formatted(dx))
+	}
+
+	// separable of 3×3 on 4 channels to 8, 4×9 and 4×8 weights
+	sep := NewHLayerSeparableConv(NewConvKParam([]int{3, 3, 8}, []int{1, 1}, ConvKernalPadAll))
+	if out := sep.InitSize([]int{5, 5, 4}); !common.IntsEqual(out, []int{5, 5, 8}) {
+		t.Fatalf("separable size wrong: %v", out)
+	}
+	dr, dc := sep.Depthwise.W.Dims()
+	if pr, pc := sep.Pointwise.W.Dims(); dr != 9 || dc != 4 || pr != 4 || pc != 8 {
+		t.Fatalf("separable weights wrong, depthwise (%d,%d), pointwise (%d,%d)", dr, dc, pr, pc)
+	}
+}
+
+func TestHLayerConvTranspose(t *testing.T) {
+	// the forward of the transpose is the backward of the conv with the same w
+	param := NewConvKParam([]int{2, 2, 3}, []int{2, 1}, ConvKernalPadNo)
+	conv, convT := NewHLayerConv(param), NewHLayerConvTranspose(param)
+	if out := convT.InitSize([]int{2, 3, 3}); !common.IntsEqual(out, []int{4, 4, 3}) {
+		t.Fatalf("conv transpose size wrong: %v", out)
+	}
+	conv.InitSize([]int{4, 4, 3})
+	convT.W.Copy(conv.W)
+	x := mat.NewDense(18, 2, nil)
+	x.Apply(func(i, j int, v float64) float64 { return float64(i*2+j) / 10 }, x)
+	conv.Forward(mat.NewDense(48, 2, nil))
+	if need, y := conv.Backward(x), convT.Forward(x); !mat.EqualApprox(need, y, 1e-12) {
+		t.Fatalf("conv transpose forward wrong need:\n%v\nbut:\n%v\n", mat.Formatted(need), mat.Formatted(y))
+	}
+}
+
+func TestHLayerUpsampling(t *testing.T) {
+	x := mat.NewDense(4, 1, []float64{
+		1, 2,
+		3, 4,
+	})
+	nearest := NewHLayerUpsamplingNearest([]int{1, 2})
+	if out := nearest.InitSize([]int{2, 2, 1}); !common.IntsEqual(out, []int{2, 4, 1}) {
+		t.Fatalf("upsampling size wrong: %v", out)
+	}
+	ytar := mat.NewDense(8, 1, []float64{
+		1, 1, 2, 2,
+		3, 3, 4, 4,
+	})
+	if y := nearest.Forward(x); !mat.Equal(ytar, y) {
+		t.Fatalf("nearest upsampling forward wrong need:\n%v\nbut:\n%v\n", ytar, y)
+	}
+	if dx := nearest.Backward(ytar); !mat.Equal(mat.NewDense(4, 1, []float64{2, 4, 6, 8}), dx) {
+		t.Fatalf("nearest upsampling backward wrong: %v", dx)
+	}
+	bilinear := NewHLayerUpsamplingBilinear([]int{1, 2})
+	bilinear.InitSize([]int{2, 2, 1})
+	ytar = mat.NewDense(8, 1, []float64{
+		1, 1.25, 1.75, 2,
+		3, 3.25, 3.75, 4,
+	})
+	if y := bilinear.Forward(x); !mat.EqualApprox(ytar, y, 1e-12) {
+		t.Fatalf("bilinear upsampling forward wrong need:\n%v\nbut:\n%v\n", ytar, y)
+	}
+}
+
+func TestHLayerAvgPooling(t *testing.T) {
+	layer := NewHLayerAvgPooling(NewConvKParam([]int{2, 2}, []int{2, 2}, ConvKernalPadNo))
+	if out := layer.InitSize([]int{2, 4, 1}); !common.IntsEqual(out, []int{1, 2, 1}) {
+		t.Fatalf("avgpooling size wrong: %v", out)
+	}
+	x := mat.NewDense(8, 1, []float64{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+	})
+	y := layer.Forward(x)
+	if ytar := mat.NewDense(2, 1, []float64{3.5, 5.5}); !mat.Equal(ytar, y) {
+		t.Fatalf("avgpooling forward wrong need:\n%v\nbut:\n%v\n", ytar, y)
+	}
+	dx := layer.Backward(mat.NewDense(2, 1, []float64{4, 8}))
+	dxtar := mat.NewDense(8, 1, []float64{
+		1, 1, 2, 2,
+		1, 1, 2, 2,
+	})
+	if !mat.Equal(dxtar, dx) {
+		t.Fatalf("avgpooling backward wrong need:\n%v\nbut:\n%v\n", dxtar, dx)
+	}
+}
+
+func TestHLayerGlobalPooling(t *testing.T) {
+	// 2 positions of 2 channels, 2 samples
+	x := mat.NewDense(4, 2, []float64{
+		1, 0,
+		5, 4,
+		3, 2,
+		2, 6,
+	})
+	avg, max := NewHLayerGlobalAvgPooling(), NewHLayerGlobalMaxPooling()
+	avg.InitSize([]int{2, 2})
+	if out := max.InitSize([]int{2, 2}); !common.IntsEqual(out, []int{2}) {
+		t.Fatalf("global pooling size wrong: %v", out)
+	}
+	if ytar := mat.NewDense(2, 2, []float64{2, 1, 3.5, 5}); !mat.Equal(ytar, avg.Forward(x)) {
+		t.Fatalf("global avgpooling forward wrong need:\n%v\n", mat.Formatted(ytar))
+	}
+	if ytar := mat.NewDense(2, 2, []float64{3, 2, 5, 6}); !mat.Equal(ytar, max.Forward(x)) {
+		t.Fatalf("global maxpooling forward wrong need:\n%v\n", mat.Formatted(ytar))
+	}
+	dy := mat.NewDense(2, 2, []float64{1, 2, 3, 4})
+	if dxtar := mat.NewDense(4, 2, []float64{0.5, 1, 1.5, 2, 0.5, 1, 1.5, 2}); !mat.Equal(dxtar, avg.Backward(dy)) {
+		t.Fatalf("global avgpooling backward wrong need:\n%v\n", mat.Formatted(dxtar))
+	}
+	if dxtar := mat.NewDense(4, 2, []float64{0, 0, 3, 0, 1, 2, 0, 4}); !mat.Equal(dxtar, max.Backward(dy)) {
+		t.Fatalf("global maxpooling backward wrong need:\n%v\n", mat.Formatted(dxtar))
+	}
+}
+
+func TestHLayerSpatialDropout(t *testing.T) {
+	layer := NewHLayerSpatialDropout(0.5, 1)
+	layer.InitSize([]int{2, 2, 4})
+	x := mat.NewDense(16, 8, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return float64(i*8 + j + 1)
+	}, x)
+	y := layer.Forward(x)
+	dropCnt := 0
+	for j := 0; j < 8; j++ {
+		for k := 0; k < 4; k++ {
+			ratio := y.At(k, j) / x.At(k, j)
+			if ratio == 0 {
+				dropCnt++
+			} else if ratio != 2 {
+				t.Fatalf("spatial dropout scale need:2 but:%f", ratio)
+			}
+			for i := k; i < 16; i += 4 {
+				if y.At(i, j)/x.At(i, j) != ratio {
+					t.Fatalf("spatial dropout need same mask in channel %d of batch %d", k, j)
+				}
+			}
+		}
+	}
+	if dropCnt == 0 || dropCnt == 32 {
+		t.Fatalf("spatial dropout drop count wrong:%d", dropCnt)
+	}
+	dy := mat.NewDense(16, 8, nil)
+	dy.Apply(func(i, j int, v float64) float64 { return 1 }, dy)
+	dx := layer.Backward(dy)
+	if !mat.Equal(dx, layer.Mask) {
+		t.Fatalf("spatial dropout backward need mask")
+	}
+	if !mat.Equal(layer.Predict(x), x) {
+		t.Fatalf("spatial dropout predict need identity")
+	}
+}
+
+func TestHLayerGroupNorm(t *testing.T) {
+	layer := NewHLayerGroupNorm(2, 0)
+	layer.InitSize([]int{3, 4})
+	x := mat.NewDense(12, 1, []float64{
+		1, 2, 10, 40,
+		3, 4, 20, 10,
+		5, 9, 30, 70,
+	})
+	y := layer.Forward(x)
+	// channels 0,1 and 2,3 are normalized separately
+	for g := 0; g < 2; g++ {
+		sum, sumSq := 0.0, 0.0
+		for i := 0; i < 12; i++ {
+			if (i%4)/2 != g {
+				continue
+			}
+			sum += y.At(i, 0)
+			sumSq += y.At(i, 0) * y.At(i, 0)
+		}
+		if math.Abs(sum) > 1e-9 || math.Abs(sumSq/6-1) > 1e-9 {
+			t.Fatalf("group %d not normalized, sum:%f, sumSq:%f", g, sum, sumSq)
+		}
+	}
+}
+
+func newParallelModel() *nn.Model {
+	m := nn.NewModel()
+	conv := NewHLayerConv(NewConvKParam([]int{2, 2, 2}, []int{1, 1}, ConvKernalPadNo))
+	size := conv.InitSize([]int{5, 5, 1})
+	bn := NewHLayerConvBatchNorm(0.0001, 0.9)
+	bn.InitSize(size)
+	pool := NewHLayerMaxPooling(NewConvKParam([]int{2, 2}, []int{2, 2}, ConvKernalPadNo))
+	size = pool.InitSize(size)
+	gn := NewHLayerGroupNorm(1, 0.0001)
+	gn.InitSize(size)
+	m.AddLayer(nn.NewOptMomentum(0.1, 0.5), conv, bn, nn.NewHLayerRelu(), pool, gn)
+	lin := nn.NewHLayerLinear()
+	lin.InitSize([]int{2, common.IntsProd(size)})
+	m.AddLayer(nn.NewOptMomentum(0.1, 0.5), lin)
+	m.SetTarget(nn.NewTarCE(), nn.NewLossParam())
+	return m
+}
+
+func TestReplicaBeforeInit(t *testing.T) {
+	param := NewConvKParam([]int{2, 2, 3}, []int{1, 1}, ConvKernalPadNo)
+	if NewHLayerConv(param).Replica() != nil || NewHLayerConvTranspose(param).Replica() != nil {
+		t.Fatalf("replica before InitSize need nil")
+	}
+}
+
+func TestModelParallel(t *testing.T) {
+	x := mat.NewDense(25, 5, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return math.Sin(float64(i*5 + j))
+	}, x)
+	y := mat.NewDense(2, 5, []float64{
+		1, 0, 1, 0, 1,
+		0, 1, 0, 1, 0,
+	})
+	serial := newParallelModel()
+	buf := &bytes.Buffer{}
+	if err := serial.Save(buf); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	parallel := newParallelModel()
+	if err := nn.Load(buf, parallel); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	parallel.Parallel(2)
+	for i := 0; i < 3; i++ {
+		serialDx := serial.Train(x, y)
+		parallelDx := parallel.Train(x, y)
+		if !mat.EqualApprox(serialDx, parallelDx, 1e-10) {
+			t.Fatalf("dx at %d not equal", i)
+		}
+	}
+	if !mat.EqualApprox(serial.Predict(x), parallel.Predict(x), 1e-10) {
+		t.Fatalf("parallel training diverged from serial")
+	}
+}
+
+func TestModelSummary(t *testing.T) {
+	m := newParallelModel()
+	m.SetInputSize([]int{5, 5, 1})
+	s := m.Summary()
+	need := []struct {
+		out     []int
+		params  int
+		mulAdds int
+	}{
+		{[]int{4, 4, 2}, 40, 128},
+		{[]int{4, 4, 2}, 4, 0},
+		{[]int{4, 4, 2}, 0, 0},
+		{[]int{2, 2, 2}, 0, 32},
+		{[]int{2, 2, 2}, 4, 0},
+		{[]int{2}, 18, 16},
+	}
+	if len(s.Rows) != len(need) {
+		t.Fatalf("rows need %d, but %d", len(need), len(s.Rows))
+	}
+	for i, n := range need {
+		row := s.Rows[i]
+		if !common.IntsEqual(row.Out, n.out) || row.Params != n.params || row.MulAdds != n.mulAdds {
+			t.Fatalf("row %d (%s) need %v %d %d, but %v %d %d", i, row.Type, n.out, n.params, n.mulAdds, row.Out, row.Params, row.MulAdds)
+		}
+	}
+	if s.Params != 66 || s.MulAdds != 176 {
+		t.Fatalf("total need 66 params and 176 mul-adds, but %d and %d", s.Params, s.MulAdds)
+	}
+	if !strings.Contains(s.String(), "cnn.HLayerConv") {
+		t.Fatalf("table lacks the layer type:\n%s", s)
+	}
+}
@@ -30,6 +30,13 @@ func (b *ModelSizeBuilder) BulldWithSize(model *nn.Model, cb func(initer common.
 		for _, layFun := range b.Lays {
 			conv.Lays = append(conv.Lays, layFun())
 		}
+		initer := conv.Initer
+		if initer == nil && b.Initer != nil {
+			initer = b.Initer()
+		}
+		if initer != nil {
+			common.SetIniter(initer, conv.Lays...)
+		}
 		for _, lay := range conv.Lays {
 			initer, ok := lay.(common.IHLayerSizeIniter)
 			if ok {
@@ -87,12 +94,21 @@ func (m *ModelBuilder) FOpt(l func() common.IOptimizer) {
 	m.f.Opt(l)
 }
 
+func (m *ModelBuilder) CInit(i func() common.IInitializer) {
+	m.c.Init(i)
+}
+
+func (m *ModelBuilder) FInit(i func() common.IInitializer) {
+	m.f.Init(i)
+}
+
 func (m *ModelBuilder) Tar(l common.ITarget) {
 	m.tar = l
 }
 
 func (m *ModelBuilder) Build() *nn.Model {
 	model := nn.NewModel()
+	model.SetInputSize(m.c.Size)
 	csize := m.c.Bulld(model)
 	fsize := append([]int{common.IntsProd(csize)}, m.f.Size...)
 	rest := len(m.f.Size) - len(m.f.Uniques)
@@ -0,0 +1,197 @@
+package cnn
+
+import (
+	"fmt"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// the rows of a column are the sample in row-major order, reshaping keeps the order,
+// so the reshape layers only change the size and pass the data through as it is
+
+// Reshape to size, one of the dims may be -1 for the rest
+type HLayerReshape struct {
+	size []int
+	out  []int
+}
+
+func NewHLayerReshape(size []int) *HLayerReshape {
+	return &HLayerReshape{size: size}
+}
+
+func (l *HLayerReshape) Replica() common.IHLayer {
+	return &HLayerReshape{size: l.size, out: l.out}
+}
+
+func (l *HLayerReshape) InitSize(size []int) []int {
+	sum := common.IntsProd(size)
+	l.out = append([]int{}, l.size...)
+	rest, known := -1, 1
+	for i, v := range l.out {
+		if v == -1 && rest < 0 {
+			rest = i
+			continue
+		}
+		if v <= 0 {
+			panic(fmt.Sprintf("reshape size %v need positive dims and one -1 at most", l.size))
+		}
+		known *= v
+	}
+	if rest >= 0 {
+		l.out[rest] = sum / known
+	}
+	if common.IntsProd(l.out) != sum {
+		panic(fmt.Sprintf("reshape size %v not fit %v", l.size, size))
+	}
+	return l.out
+}
+
+func (l *HLayerReshape) Summary(size []int) (out []int, mulAdds int) {
+	return l.out, 0
+}
+
+func (l *HLayerReshape) Forward(x *mat.Dense) (y *mat.Dense) {
+	return x
+}
+
+func (l *HLayerReshape) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	return dy
+}
+
+// Flatten to one dim, as the input of a linear
+type HLayerFlatten struct {
+	HLayerReshape
+}
+
+func NewHLayerFlatten() *HLayerFlatten {
+	return &HLayerFlatten{HLayerReshape{size: []int{-1}}}
+}
+
+func (l *HLayerFlatten) Replica() common.IHLayer {
+	return &HLayerFlatten{*l.HLayerReshape.Replica().(*HLayerReshape)}
+}
+
+// Squeeze drops the dims of size 1, all of them without dims
+type HLayerSqueeze struct {
+	HLayerReshape
+	dims []int
+}
+
+func NewHLayerSqueeze(dims ...int) *HLayerSqueeze {
+	return &HLayerSqueeze{dims: dims}
+}
+
+func (l *HLayerSqueeze) Replica() common.IHLayer {
+	return &HLayerSqueeze{*l.HLayerReshape.Replica().(*HLayerReshape), l.dims}
+}
+
+func (l *HLayerSqueeze) InitSize(size []int) []int {
+	drop := make([]bool, len(size))
+	if len(l.dims) == 0 {
+		for i, v := range size {
+			drop[i] = v == 1
+		}
+	}
+	for _, d := range l.dims {
+		if d < 0 || d >= len(size) || size[d] != 1 {
+			panic(fmt.Sprintf("squeeze dim %d not of size 1 in %v", d, size))
+		}
+		drop[d] = true
+	}
+	l.size = []int{}
+	for i, v := range size {
+		if !drop[i] {
+			l.size = append(l.size, v)
+		}
+	}
+	return l.HLayerReshape.InitSize(size)
+}
+
+// Unsqueeze inserts a dim of size 1 before dim, dim as the dim count appends it
+type HLayerUnsqueeze struct {
+	HLayerReshape
+	dim int
+}
+
+func NewHLayerUnsqueeze(dim int) *HLayerUnsqueeze {
+	return &HLayerUnsqueeze{dim: dim}
+}
+
+func (l *HLayerUnsqueeze) Replica() common.IHLayer {
+	return &HLayerUnsqueeze{*l.HLayerReshape.Replica().(*HLayerReshape), l.dim}
+}
+
+func (l *HLayerUnsqueeze) InitSize(size []int) []int {
+	if l.dim < 0 || l.dim > len(size) {
+		panic(fmt.Sprintf("unsqueeze dim %d out of %v", l.dim, size))
+	}
+	l.size = append(append(append([]int{}, size[:l.dim]...), 1), size[l.dim:]...)
+	return l.HLayerReshape.InitSize(size)
+}
+
+// Permute reorders the dims, dim i of the output is dim order[i] of the input,
+// rows are moved by the picker of the input size, backward moves them back
+type HLayerPermute struct {
+	order  []int
+	out    []int
+	picker *MatPicker
+	gather []int
+	ws     *common.Workspace
+}
+
+func NewHLayerPermute(order []int) *HLayerPermute {
+	return &HLayerPermute{order: order}
+}
+
+// the picker and the gather are only read, so replicas share them
+func (l *HLayerPermute) Replica() common.IHLayer {
+	if l.gather == nil {
+		return nil
+	}
+	return &HLayerPermute{order: l.order, out: l.out, picker: l.picker, gather: l.gather}
+}
+
+func (l *HLayerPermute) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerPermute) InitSize(size []int) []int {
+	if len(l.order) != len(size) {
+		panic(fmt.Sprintf("permute order %v need %d dims", l.order, len(size)))
+	}
+	seen := make([]bool, len(size))
+	l.out = make([]int, len(size))
+	for i, d := range l.order {
+		if d < 0 || d >= len(size) || seen[d] {
+			panic(fmt.Sprintf("permute order %v not a permutation of %d dims", l.order, len(size)))
+		}
+		seen[d] = true
+		l.out[i] = size[d]
+	}
+	l.picker = NewMatPicker(size, 0)
+	l.gather = l.picker.Permute(l.order)
+	return l.out
+}
+
+func (l *HLayerPermute) Summary(size []int) (out []int, mulAdds int) {
+	return l.out, 0
+}
+
+func (l *HLayerPermute) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(r, c)
+	for o, i := range l.gather {
+		copy(y.RawRowView(o), x.RawRowView(i))
+	}
+	return
+}
+
+func (l *HLayerPermute) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	for o, i := range l.gather {
+		copy(dx.RawRowView(i), dy.RawRowView(o))
+	}
+	return
+}
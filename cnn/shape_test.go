@@ -0,0 +1,58 @@
+package cnn
+
+import (
+	"fmt"
+	"pneuma/common"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestHLayerShape(t *testing.T) {
+	cases := []struct {
+		name  string
+		layer common.IHLayerSizeIniter
+		size  []int
+		out   []int
+	}{
+		{"reshape", NewHLayerReshape([]int{3, -1}), []int{2, 3, 2}, []int{3, 4}},
+		{"flatten", NewHLayerFlatten(), []int{2, 3, 2}, []int{12}},
+		{"squeeze", NewHLayerSqueeze(), []int{1, 3, 1, 2}, []int{3, 2}},
+		{"squeeze_dim", NewHLayerSqueeze(2), []int{1, 3, 1, 2}, []int{1, 3, 2}},
+		{"unsqueeze", NewHLayerUnsqueeze(1), []int{3, 2}, []int{3, 1, 2}},
+		{"permute", NewHLayerPermute([]int{2, 0, 1}), []int{2, 3, 2}, []int{2, 2, 3}},
+	}
+	x := mat.NewDense(12, 2, nil)
+	x.Apply(func(i, j int, v float64) float64 { return float64(i*2 + j) }, x)
+	for _, c := range cases {
+		if out := c.layer.InitSize(c.size); !common.IntsEqual(out, c.out) {
+			t.Fatalf("%s size need %v, but %v", c.name, c.out, out)
+		}
+		y := c.layer.Forward(x)
+		// backward is the exact inverse of forward
+		if dx := c.layer.Backward(y); !mat.Equal(x, dx) {
+			t.Fatalf("%s backward not the inverse of forward", c.name)
+		}
+		rep := c.layer.(common.IHLayerReplicator).Replica()
+		if fmt.Sprintf("%T", rep) != fmt.Sprintf("%T", c.layer) || !mat.Equal(rep.Forward(x), y) {
+			t.Fatalf("%s replica %T not forwarding as the layer", c.name, rep)
+		}
+	}
+	if NewHLayerPermute([]int{1, 0}).Replica() != nil {
+		t.Fatalf("permute replica before InitSize need nil")
+	}
+
+	// channels to the front, a [2,3,2] of rows r*6+c*2+ch goes to ch*6+r*3+c
+	permute := NewHLayerPermute([]int{2, 0, 1})
+	permute.InitSize([]int{2, 3, 2})
+	y := permute.Forward(x)
+	for r := 0; r < 2; r++ {
+		for c := 0; c < 3; c++ {
+			for ch := 0; ch < 2; ch++ {
+				if y.At(ch*6+r*3+c, 1) != x.At(r*6+c*2+ch, 1) {
+					t.Fatalf("permute moved (%d,%d,%d) wrong", r, c, ch)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type FanMode int16
+
+const (
+	FanIn FanMode = iota
+	FanOut
+	FanAvg
+)
+
+// uniform in [-0.5, 0.5), the initializer layers used before
+type InitRand struct {
+}
+
+func NewInitRand() *InitRand {
+	return &InitRand{}
+}
+
+func (i *InitRand) Init(w *mat.Dense, fanIn, fanOut int) {
+	w.Apply(func(r, c int, v float64) float64 {
+		return rand.Float64() - 0.5
+	}, w)
+}
+
+type InitConst struct {
+	val float64
+}
+
+func NewInitConst(val float64) *InitConst {
+	return &InitConst{val: val}
+}
+
+func (i *InitConst) Init(w *mat.Dense, fanIn, fanOut int) {
+	w.Apply(func(r, c int, v float64) float64 {
+		return i.val
+	}, w)
+}
+
+// variance = scale / fan, drawn from a uniform or a normal distribution
+type InitVarScaling struct {
+	scale  float64
+	mode   FanMode
+	normal bool
+}
+
+func NewInitVarScaling(scale float64, mode FanMode, normal bool) *InitVarScaling {
+	return &InitVarScaling{
+		scale:  scale,
+		mode:   mode,
+		normal: normal,
+	}
+}
+
+func NewInitXavier(normal bool) *InitVarScaling {
+	return NewInitVarScaling(1, FanAvg, normal)
+}
+
+func NewInitHe(normal bool) *InitVarScaling {
+	return NewInitVarScaling(2, FanIn, normal)
+}
+
+func NewInitLeCun(normal bool) *InitVarScaling {
+	return NewInitVarScaling(1, FanIn, normal)
+}
+
+func (i *InitVarScaling) Init(w *mat.Dense, fanIn, fanOut int) {
+	var fan float64
+	switch i.mode {
+	case FanIn:
+		fan = float64(fanIn)
+	case FanOut:
+		fan = float64(fanOut)
+	case FanAvg:
+		fan = float64(fanIn+fanOut) / 2
+	}
+	variance := i.scale / math.Max(fan, 1)
+	if i.normal {
+		std := math.Sqrt(variance)
+		w.Apply(func(r, c int, v float64) float64 {
+			return rand.NormFloat64() * std
+		}, w)
+	} else {
+		limit := math.Sqrt(3 * variance)
+		w.Apply(func(r, c int, v float64) float64 {
+			return (rand.Float64()*2 - 1) * limit
+		}, w)
+	}
+}
+
+type InitOrthogonal struct {
+	gain float64
+}
+
+func NewInitOrthogonal(gain float64) *InitOrthogonal {
+	return &InitOrthogonal{gain: gain}
+}
+
+func (i *InitOrthogonal) Init(w *mat.Dense, fanIn, fanOut int) {
+	r, c := w.Dims()
+	trans := r < c
+	if trans {
+		r, c = c, r
+	}
+	a := mat.NewDense(r, c, nil)
+	a.Apply(func(i, j int, v float64) float64 {
+		return rand.NormFloat64()
+	}, a)
+	qr := mat.QR{}
+	qr.Factorize(a)
+	q := mat.NewDense(r, r, nil)
+	qr.QTo(q)
+	rm := mat.NewDense(c, c, nil)
+	qr.RTo(rm)
+	q = q.Slice(0, r, 0, c).(*mat.Dense)
+	for j := 0; j < c; j++ {
+		if rm.At(j, j) < 0 {
+			col := q.ColView(j).(*mat.VecDense)
+			col.ScaleVec(-1, col)
+		}
+	}
+	if trans {
+		w.Scale(i.gain, q.T())
+	} else {
+		w.Scale(i.gain, q)
+	}
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
+)
+
+func TestInitVarScaling(t *testing.T) {
+	w := mat.NewDense(200, 100, nil)
+	NewInitXavier(false).Init(w, 100, 200)
+	limit := math.Sqrt(6.0 / 300)
+	for _, v := range w.RawMatrix().Data {
+		if math.Abs(v) > limit {
+			t.Fatalf("xavier uniform need in [-%f, %f] but:%f", limit, limit, v)
+		}
+	}
+	NewInitHe(true).Init(w, 100, 200)
+	std := stat.StdDev(w.RawMatrix().Data, nil)
+	if math.Abs(std-math.Sqrt(2.0/100)) > 0.01 {
+		t.Fatalf("he normal std need:%f but:%f", math.Sqrt(2.0/100), std)
+	}
+}
+
+func TestInitOrthogonal(t *testing.T) {
+	for _, dims := range [][]int{{6, 4}, {4, 6}} {
+		w := mat.NewDense(dims[0], dims[1], nil)
+		NewInitOrthogonal(2).Init(w, dims[1], dims[0])
+		n := IntsMin(dims...)
+		prod := mat.NewDense(n, n, nil)
+		if dims[0] >= dims[1] {
+			prod.Mul(w.T(), w)
+		} else {
+			prod.Mul(w, w.T())
+		}
+		tar := NewEDense(n)
+		tar.Scale(4, tar)
+		if !mat.EqualApprox(prod, tar, 1e-9) {
+			t.Fatalf("orthogonal %v need:\n%v\nbut:\n%v\n", dims, mat.Formatted(tar), mat.Formatted(prod))
+		}
+	}
+}
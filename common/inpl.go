@@ -3,16 +3,37 @@ package common
 import "gonum.org/v1/gonum/mat"
 
 func OptimizeData(layers ...IHLayer) (optDatas []mat.Matrix, optDeltas []mat.Matrix) {
+	return AppendOptimizeData(nil, nil, layers...)
+}
+
+// AppendOptimizeData appends to datas and deltas, so a caller keeping them allocates once
+func AppendOptimizeData(datas, deltas []mat.Matrix, layers ...IHLayer) ([]mat.Matrix, []mat.Matrix) {
 	for i := 0; i < len(layers); i++ {
 		opt, isOpt := layers[i].(IHLayerOptimizer)
 		if !isOpt {
 			continue
 		}
 		optData, optDelta := opt.Optimize()
-		optDatas = append(optDatas, optData...)
-		optDeltas = append(optDeltas, optDelta...)
+		datas = append(datas, optData...)
+		deltas = append(deltas, optDelta...)
+	}
+	return datas, deltas
+}
+
+func SetIniter(initer IInitializer, layers ...IHLayer) {
+	for i := 0; i < len(layers); i++ {
+		if lay, isLay := layers[i].(IHLayerWeightIniter); isLay {
+			lay.SetIniter(initer)
+		}
+	}
+}
+
+func SetWorkspace(ws *Workspace, layers ...IHLayer) {
+	for i := 0; i < len(layers); i++ {
+		if lay, isLay := layers[i].(IHLayerWorkspacer); isLay {
+			lay.SetWorkspace(ws)
+		}
 	}
-	return
 }
 
 func Predic(layer IHLayer, x *mat.Dense) *mat.Dense {
@@ -1,6 +1,10 @@
 package common
 
-import "gonum.org/v1/gonum/mat"
+import (
+	"io"
+
+	"gonum.org/v1/gonum/mat"
+)
 
 type IHLayer interface {
 	Forward(x *mat.Dense) (y *mat.Dense)
@@ -17,11 +21,35 @@ type IHLayerPredictor interface {
 	Predict(x *mat.Dense) (y *mat.Dense)
 }
 
+// a layer sharing the optimized datas but owning its caches, nil when not replicable
+type IHLayerReplicator interface {
+	IHLayer
+	Replica() IHLayer
+}
+
+type IHLayerWorkspacer interface {
+	IHLayer
+	SetWorkspace(ws *Workspace)
+}
+
 type IHLayerSizeIniter interface {
 	IHLayer
 	InitSize([]int) []int
 }
 
+// Summary gives the output size and the multiply-adds of one sample for the input size
+type IHLayerSummarizer interface {
+	IHLayer
+	Summary(size []int) (out []int, mulAdds int)
+}
+
+// merges the outputs of several nodes of a graph, Backward splits dy back to them
+type IMerger interface {
+	Forward(xs []*mat.Dense) *mat.Dense
+	Backward(dy *mat.Dense) []*mat.Dense
+	Size(sizes [][]int) []int
+}
+
 type IOptimizer interface {
 	Update(datas, deltas []mat.Matrix)
 }
@@ -37,3 +65,50 @@ type ITarget interface {
 	LossEach(pred, targ *mat.Dense) (loss *mat.Dense)
 	Backward() (dy *mat.Dense)
 }
+
+type IHLayerStater interface {
+	IHLayer
+	State() (states []mat.Matrix)
+}
+
+type IOptimizerStater interface {
+	IOptimizer
+	State() (states []mat.Matrix, scalars []float64)
+	SetState(states []mat.Matrix, scalars []float64)
+}
+
+type IOptimizerLR interface {
+	IOptimizer
+	LR() float64
+	SetLR(lr float64)
+}
+
+type IOptimizerEpoch interface {
+	IOptimizer
+	EpochEnd(loss float64)
+}
+
+type IInitializer interface {
+	Init(w *mat.Dense, fanIn, fanOut int)
+}
+
+type IHLayerWeightIniter interface {
+	IHLayer
+	SetIniter(initer IInitializer)
+}
+
+type IOptimizerPreparer interface {
+	IOptimizer
+	Prepare(datas, deltas []mat.Matrix)
+}
+
+type IModel interface {
+	Train(x, y *mat.Dense) *mat.Dense
+	Tests(x, y []*mat.Dense) (loss, acc float64)
+	IsDone() bool
+	LossLatest() float64
+	LossPopMean() float64
+	EpochEnd(loss float64)
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
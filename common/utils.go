@@ -1,6 +1,8 @@
 package common
 
 import (
+	"gonum.org/v1/gonum/blas"
+	"gonum.org/v1/gonum/blas/blas64"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -194,3 +196,24 @@ func OneHotVecs(cnt int) []*mat.VecDense {
 	}
 	return ret
 }
+
+// MulTrans sets dst = a*b with a or b transposed as asked, unlike Mul of a.T()
+// it boxes no transpose, dst must be sized and not share data with a or b
+func MulTrans(dst, a, b *mat.Dense, aTrans, bTrans bool) {
+	ar, ac := a.Dims()
+	br, bc := b.Dims()
+	dr, dc := dst.Dims()
+	ta, tb := blas.NoTrans, blas.NoTrans
+	if aTrans {
+		ar, ac = ac, ar
+		ta = blas.Trans
+	}
+	if bTrans {
+		br, bc = bc, br
+		tb = blas.Trans
+	}
+	if ac != br || dr != ar || dc != bc {
+		panic(mat.ErrShape)
+	}
+	blas64.Gemm(ta, tb, 1, a.RawMatrix(), b.RawMatrix(), 0, dst.RawMatrix())
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"sync"
+
+	"gonum.org/v1/gonum/blas/blas64"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Workspace lends zeroed matrices by shape and takes all of them back on Reset,
+// so a training step borrows the buffers the last step used, a nil Workspace allocates
+type Workspace struct {
+	mu    sync.Mutex
+	dense map[[2]int]*densePool
+	vec   map[int]*vecPool
+	views vecPool
+}
+
+type densePool struct {
+	items []*mat.Dense
+	used  int
+}
+
+type vecPool struct {
+	items []*mat.VecDense
+	used  int
+}
+
+func NewWorkspace() *Workspace {
+	return &Workspace{
+		dense: make(map[[2]int]*densePool),
+		vec:   make(map[int]*vecPool),
+	}
+}
+
+func (w *Workspace) Dense(r, c int) *mat.Dense {
+	if w == nil {
+		return mat.NewDense(r, c, nil)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := [2]int{r, c}
+	pool := w.dense[key]
+	if pool == nil {
+		pool = &densePool{}
+		w.dense[key] = pool
+	}
+	if pool.used < len(pool.items) {
+		d := pool.items[pool.used]
+		pool.used++
+		d.Zero()
+		return d
+	}
+	d := mat.NewDense(r, c, nil)
+	pool.items = append(pool.items, d)
+	pool.used++
+	return d
+}
+
+func (w *Workspace) VecDense(n int) *mat.VecDense {
+	if w == nil {
+		return mat.NewVecDense(n, nil)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pool := w.vec[n]
+	if pool == nil {
+		pool = &vecPool{}
+		w.vec[n] = pool
+	}
+	if pool.used < len(pool.items) {
+		v := pool.items[pool.used]
+		pool.used++
+		v.Zero()
+		return v
+	}
+	v := mat.NewVecDense(n, nil)
+	pool.items = append(pool.items, v)
+	pool.used++
+	return v
+}
+
+// view lends a vector header on raw, nil workspace allocates one
+func (w *Workspace) view(raw blas64.Vector) *mat.VecDense {
+	if w == nil {
+		v := &mat.VecDense{}
+		v.SetRawVector(raw)
+		return v
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pool := &w.views
+	if pool.used == len(pool.items) {
+		pool.items = append(pool.items, &mat.VecDense{})
+	}
+	v := pool.items[pool.used]
+	pool.used++
+	v.SetRawVector(raw)
+	return v
+}
+
+// ColView borrows a vector sharing the column j of m
+func (w *Workspace) ColView(m *mat.Dense, j int) *mat.VecDense {
+	r, c := m.Dims()
+	if j < 0 || j >= c {
+		panic(mat.ErrColAccess)
+	}
+	raw := m.RawMatrix()
+	return w.view(blas64.Vector{N: r, Inc: raw.Stride, Data: raw.Data[j : (r-1)*raw.Stride+j+1]})
+}
+
+// RowView borrows a vector sharing the row i of m
+func (w *Workspace) RowView(m *mat.Dense, i int) *mat.VecDense {
+	r, c := m.Dims()
+	if i < 0 || i >= r {
+		panic(mat.ErrRowAccess)
+	}
+	raw := m.RawMatrix()
+	return w.view(blas64.Vector{N: c, Inc: 1, Data: raw.Data[i*raw.Stride : i*raw.Stride+c]})
+}
+
+// DenseCopyOf borrows a matrix holding a copy of a
+func (w *Workspace) DenseCopyOf(a mat.Matrix) *mat.Dense {
+	r, c := a.Dims()
+	d := w.Dense(r, c)
+	d.Copy(a)
+	return d
+}
+
+// Reset takes back every lent matrix, they must not be used after it
+func (w *Workspace) Reset() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, pool := range w.dense {
+		pool.used = 0
+	}
+	for _, pool := range w.vec {
+		pool.used = 0
+	}
+	w.views.used = 0
+}
+
+// Cap is the count of matrices the workspace holds
+func (w *Workspace) Cap() (cnt int) {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, pool := range w.dense {
+		cnt += len(pool.items)
+	}
+	for _, pool := range w.vec {
+		cnt += len(pool.items)
+	}
+	return
+}
@@ -0,0 +1,29 @@
+package common
+
+import "testing"
+
+func TestWorkspace(t *testing.T) {
+	ws := NewWorkspace()
+	a := ws.Dense(2, 3)
+	a.Set(1, 2, 5)
+	b := ws.Dense(2, 3)
+	if a == b {
+		t.Fatalf("lent a matrix twice before reset")
+	}
+	ws.Reset()
+	c := ws.Dense(2, 3)
+	if c != a || c.At(1, 2) != 0 {
+		t.Fatalf("reset matrix not reused or not zeroed")
+	}
+	if ws.Cap() != 2 {
+		t.Fatalf("cap need 2, but %d", ws.Cap())
+	}
+	a.Set(0, 1, 3)
+	if col, row := ws.ColView(a, 1), ws.RowView(a, 0); col.AtVec(0) != 3 || row.AtVec(1) != 3 || col.Len() != 2 || row.Len() != 3 {
+		t.Fatalf("views not sharing the matrix")
+	}
+	var none *Workspace
+	if r, c := none.Dense(2, 3).Dims(); r != 2 || c != 3 {
+		t.Fatalf("nil workspace dims %d, %d", r, c)
+	}
+}
@@ -1,8 +1,10 @@
 package cu
 
 import (
+	"fmt"
 	"math"
 	"pneuma/cnn"
+	"pneuma/common"
 	"pneuma/nn"
 
 	"gonum.org/v1/gonum/floats"
@@ -22,7 +24,16 @@ func NewHLayerConv(cal *MatCaltor, param cnn.ConvKernalParam) *HLayerConv {
 	}
 }
 
+// the weights live on the device, so it runs on the whole batch
+func (l *HLayerConv) Replica() common.IHLayer {
+	return nil
+}
+
+// the device only runs dense convs, the grouped ones are left to cnn
 func (l *HLayerConv) InitSize(size []int) []int {
+	if l.Groups() > 1 {
+		panic(fmt.Sprintf("cu conv not supports groups, but %d", l.Groups()))
+	}
 	ret := l.HLayerConv.InitSize(size)
 	l.cal.CopyTo(l.W, l.DW, l.B, l.DB)
 	return ret
@@ -37,9 +48,9 @@ func (l *HLayerConv) Predict(x *mat.Dense) (y *mat.Dense) {
 
 func (l *HLayerConv) Forward(x *mat.Dense) (y *mat.Dense) {
 	batch := x.RawMatrix().Cols
-	wr, _ := l.W.Dims()
+	_, coreSizeSum := l.C.CoreSize()
 	br, bc := l.B.Dims()
-	packX := mat.NewDense(br*batch, wr, nil)
+	packX := mat.NewDense(br*batch, coreSizeSum, nil)
 	l.C.FoldBatches(x, packX, l.C.PackTo)
 	packY := mat.NewDense(br*batch, bc, nil)
 	l.PackX = packX
@@ -59,13 +70,13 @@ func (l *HLayerConv) Forward(x *mat.Dense) (y *mat.Dense) {
 
 func (l *HLayerConv) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	batch := dy.RawMatrix().Cols
-	wr, _ := l.W.Dims()
+	_, coreSizeSum := l.C.CoreSize()
 	br, bc := l.B.Dims()
 	l.DW.Zero()
 	l.DB.Zero()
 	packDy := mat.NewDense(br*batch, bc, nil)
 	l.C.FoldBatches(dy, packDy, nil)
-	packDx := mat.NewDense(br*batch, wr, nil)
+	packDx := mat.NewDense(br*batch, coreSizeSum, nil)
 
 	//gpu cal start
 	l.cal.CopyTo(packDx, packDy, l.DW, l.DB)
@@ -0,0 +1,185 @@
+package def
+
+import (
+	"errors"
+	"fmt"
+	"pneuma/cnn"
+	"pneuma/common"
+	"pneuma/frcnn"
+	"pneuma/nn"
+)
+
+func Build(d *Def) (*nn.Model, error) {
+	return Default.Build(d)
+}
+
+func BuildFRCNN(d *Def) (*frcnn.Model, error) {
+	return Default.BuildFRCNN(d)
+}
+
+// Build makes a cnn model, without conv blocks the full blocks take the input directly
+func (r *Registry) Build(d *Def) (*nn.Model, error) {
+	if d.RPN != nil {
+		return nil, errors.New("rpn need BuildFRCNN")
+	}
+	if d.Target == nil {
+		return nil, errors.New("target is required")
+	}
+	tar, err := r.NewTar(d.Target)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+	conv, full := d.Conv, d.Full
+	if conv == nil {
+		conv = &Stage{}
+	}
+	if full == nil {
+		full = &Stage{}
+	}
+	if len(full.Blocks) > len(full.Size) {
+		return nil, fmt.Errorf("full has %d blocks but %d sizes", len(full.Blocks), len(full.Size))
+	}
+	b := cnn.NewModelBuilder(d.Size, full.Size)
+	if err := r.stage("conv", conv, len(conv.Blocks), b.C, b.CLay, b.COpt, b.CInit); err != nil {
+		return nil, err
+	}
+	if err := r.stage("full", full, len(full.Size), b.F, b.FLay, b.FOpt, b.FInit); err != nil {
+		return nil, err
+	}
+	b.Tar(tar)
+	return b.Build(), nil
+}
+
+// BuildFRCNN makes a frcnn model from the conv blocks and the rpn
+func (r *Registry) BuildFRCNN(d *Def) (*frcnn.Model, error) {
+	if d.Full != nil || d.Target != nil {
+		return nil, errors.New("frcnn takes no full blocks or target")
+	}
+	conv := d.Conv
+	if conv == nil {
+		conv = &Stage{}
+	}
+	var roi []int
+	if d.RPN != nil {
+		roi = d.RPN.ROI
+	}
+	b := frcnn.NewModelBuilder(d.Size, roi)
+	if err := r.stage("conv", conv, len(conv.Blocks), b.C, b.CLay, b.COpt, b.CInit); err != nil {
+		return nil, err
+	}
+	if d.RPN != nil {
+		if err := r.rpn(d.RPN, b); err != nil {
+			return nil, fmt.Errorf("rpn: %w", err)
+		}
+	}
+	return b.Build(), nil
+}
+
+func (r *Registry) rpn(d *RPN, b *frcnn.ModelBuilder) error {
+	if len(d.ROI) == 0 || d.Optimizer == nil {
+		return errors.New("roi and optimizer are required")
+	}
+	layer := d.Layer
+	if layer == nil {
+		layer = &Comp{Type: "conv"}
+	}
+	opt, err := r.NewOpt(d.Optimizer)
+	if err != nil {
+		return fmt.Errorf("optimizer: %w", err)
+	}
+	if d.ScoreTarget != nil {
+		tar, err := r.NewTar(d.ScoreTarget)
+		if err != nil {
+			return fmt.Errorf("score target: %w", err)
+		}
+		b.RPNScoreTar(tar)
+	}
+	// the kernel params are only known at build, try a dummy one for the errors
+	if _, err := r.NewKernel(layer, cnn.NewConvKParam([]int{1, 1, 1}, []int{1, 1}, cnn.ConvKernalPadFit)); err != nil {
+		return fmt.Errorf("layer: %w", err)
+	}
+	b.RPN(func(score, trans cnn.ConvKernalParam) (scnv, tcnv common.IHLayerSizeIniter, o common.IOptimizer) {
+		scnv, _ = r.NewKernel(layer, score)
+		tcnv, _ = r.NewKernel(layer, trans)
+		return scnv, tcnv, opt
+	})
+	return nil
+}
+
+// stage hands the blocks of s to a builder, cnt blocks in all, the missing ones only take the shared layers,
+// shared components are built once up front for the errors, then again for every block
+func (r *Registry) stage(name string, s *Stage, cnt int,
+	one func(func(*nn.ModelSample)), lay func(func() common.IHLayer),
+	opt func(func() common.IOptimizer), initer func(func() common.IInitializer)) error {
+	for i, c := range s.Layers {
+		if _, err := r.NewLayer(c); err != nil {
+			return fmt.Errorf("%s layer %d: %w", name, i, err)
+		}
+		c := c
+		lay(func() common.IHLayer {
+			l, _ := r.NewLayer(c)
+			return l
+		})
+	}
+	if s.Optimizer != nil {
+		if _, err := r.NewOpt(s.Optimizer); err != nil {
+			return fmt.Errorf("%s optimizer: %w", name, err)
+		}
+		opt(func() common.IOptimizer {
+			o, _ := r.NewOpt(s.Optimizer)
+			return o
+		})
+	}
+	if s.Initer != nil {
+		if _, err := r.NewIniter(s.Initer); err != nil {
+			return fmt.Errorf("%s initer: %w", name, err)
+		}
+		initer(func() common.IInitializer {
+			i, _ := r.NewIniter(s.Initer)
+			return i
+		})
+	}
+	for i := 0; i < cnt; i++ {
+		blk := &Block{}
+		if i < len(s.Blocks) {
+			blk = s.Blocks[i]
+		}
+		sample, err := r.block(blk)
+		if err != nil {
+			return fmt.Errorf("%s block %d: %w", name, i, err)
+		}
+		if sample.Optimizer == nil && s.Optimizer == nil {
+			return fmt.Errorf("%s block %d has no optimizer", name, i)
+		}
+		one(func(ms *nn.ModelSample) {
+			*ms = *sample
+		})
+	}
+	return nil
+}
+
+func (r *Registry) block(b *Block) (*nn.ModelSample, error) {
+	ms := &nn.ModelSample{}
+	for i, c := range b.Layers {
+		l, err := r.NewLayer(c)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+		ms.Lay(l)
+	}
+	if b.Optimizer != nil {
+		o, err := r.NewOpt(b.Optimizer)
+		if err != nil {
+			return nil, fmt.Errorf("optimizer: %w", err)
+		}
+		ms.Opt(o)
+	}
+	if b.Initer != nil {
+		i, err := r.NewIniter(b.Initer)
+		if err != nil {
+			return nil, fmt.Errorf("initer: %w", err)
+		}
+		ms.Init(i)
+	}
+	return ms, nil
+}
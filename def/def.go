@@ -0,0 +1,335 @@
+package def
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Def describes a model, conv blocks come first, the full blocks take the flattened output
+type Def struct {
+	Size   []int  `json:"size" yaml:"size"`
+	Conv   *Stage `json:"conv,omitempty" yaml:"conv,omitempty"`
+	Full   *Stage `json:"full,omitempty" yaml:"full,omitempty"`
+	RPN    *RPN   `json:"rpn,omitempty" yaml:"rpn,omitempty"`
+	Target *Comp  `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+// Stage is a run of blocks, Layers, Optimizer and Initer are shared by every block,
+// Size is the output size of each full block
+type Stage struct {
+	Size      []int    `json:"size,omitempty" yaml:"size,omitempty"`
+	Blocks    []*Block `json:"blocks,omitempty" yaml:"blocks,omitempty"`
+	Layers    []*Comp  `json:"layers,omitempty" yaml:"layers,omitempty"`
+	Optimizer *Comp    `json:"optimizer,omitempty" yaml:"optimizer,omitempty"`
+	Initer    *Comp    `json:"initer,omitempty" yaml:"initer,omitempty"`
+}
+
+// Block is one layer of the model, its own layers run before the shared ones
+type Block struct {
+	Layers    []*Comp `json:"layers,omitempty" yaml:"layers,omitempty"`
+	Optimizer *Comp   `json:"optimizer,omitempty" yaml:"optimizer,omitempty"`
+	Initer    *Comp   `json:"initer,omitempty" yaml:"initer,omitempty"`
+}
+
+// RPN is the region proposal head of a frcnn model, Layer names a kernel layer
+type RPN struct {
+	ROI         []int `json:"roi" yaml:"roi"`
+	Layer       *Comp `json:"layer,omitempty" yaml:"layer,omitempty"`
+	Optimizer   *Comp `json:"optimizer" yaml:"optimizer"`
+	ScoreTarget *Comp `json:"score_target,omitempty" yaml:"score_target,omitempty"`
+}
+
+// Comp is a registered component and its args, written as {"type": "dropout", "rate": 0.5}
+// or only the type "relu"
+type Comp struct {
+	Type string
+	Args map[string]any
+}
+
+func (c *Comp) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		c.Args = nil
+		return json.Unmarshal(data, &c.Type)
+	}
+	args := map[string]any{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	return c.set(args)
+}
+
+func (c *Comp) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Args = nil
+		return node.Decode(&c.Type)
+	}
+	args := map[string]any{}
+	if err := node.Decode(&args); err != nil {
+		return err
+	}
+	return c.set(args)
+}
+
+func (c *Comp) MarshalJSON() ([]byte, error) {
+	if len(c.Args) == 0 {
+		return json.Marshal(c.Type)
+	}
+	args := map[string]any{"type": c.Type}
+	for k, v := range c.Args {
+		args[k] = v
+	}
+	return json.Marshal(args)
+}
+
+func (c *Comp) set(args map[string]any) error {
+	typ, ok := args["type"].(string)
+	if !ok {
+		return fmt.Errorf("component need a string type, but %v", args["type"])
+	}
+	delete(args, "type")
+	c.Type = typ
+	c.Args = args
+	return nil
+}
+
+// Parse reads a json def, unknown fields are errors
+func Parse(data []byte) (*Def, error) {
+	d := &Def{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("parse json def: %w", err)
+	}
+	return d, nil
+}
+
+// ParseYAML reads a yaml def, unknown fields are errors
+func ParseYAML(data []byte) (*Def, error) {
+	d := &Def{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("parse yaml def: %w", err)
+	}
+	return d, nil
+}
+
+// Load reads a def file, .yaml and .yml are yaml, others json
+func Load(path string) (*Def, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	default:
+		return Parse(data)
+	}
+}
+
+// Args hands the args of a Comp to its constructor, a missing arg takes the default,
+// a mistyped one is kept as an error
+type Args struct {
+	comp *Comp
+	reg  *Registry
+	used map[string]bool
+	err  error
+}
+
+func newArgs(comp *Comp, reg *Registry) *Args {
+	return &Args{comp: comp, reg: reg, used: make(map[string]bool)}
+}
+
+func (a *Args) Type() string {
+	return a.comp.Type
+}
+
+func (a *Args) Has(key string) bool {
+	_, ok := a.comp.Args[key]
+	return ok
+}
+
+func (a *Args) Fail(err error) {
+	a.err = errors.Join(a.err, err)
+}
+
+func (a *Args) get(key string) (any, bool) {
+	a.used[key] = true
+	v, ok := a.comp.Args[key]
+	return v, ok && v != nil
+}
+
+func (a *Args) mistyped(key, need string, v any) {
+	a.Fail(fmt.Errorf("arg %q need %s, but %v", key, need, v))
+}
+
+func (a *Args) Float(key string, def float64) float64 {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		a.mistyped(key, "a number", v)
+		return def
+	}
+	return f
+}
+
+func (a *Args) Int(key string, def int) int {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	i, ok := toInt(v)
+	if !ok {
+		a.mistyped(key, "an integer", v)
+		return def
+	}
+	return i
+}
+
+func (a *Args) Bool(key string, def bool) bool {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	b, ok := v.(bool)
+	if !ok {
+		a.mistyped(key, "a bool", v)
+		return def
+	}
+	return b
+}
+
+func (a *Args) String(key string, def string) string {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	s, ok := v.(string)
+	if !ok {
+		a.mistyped(key, "a string", v)
+		return def
+	}
+	return s
+}
+
+func (a *Args) Floats(key string, def []float64) []float64 {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	list, ok := v.([]any)
+	if !ok {
+		a.mistyped(key, "a number list", v)
+		return def
+	}
+	fs := make([]float64, len(list))
+	for i, item := range list {
+		if fs[i], ok = toFloat(item); !ok {
+			a.mistyped(key, "a number list", v)
+			return def
+		}
+	}
+	return fs
+}
+
+func (a *Args) Ints(key string, def []int) []int {
+	v, ok := a.get(key)
+	if !ok {
+		return def
+	}
+	list, ok := v.([]any)
+	if !ok {
+		a.mistyped(key, "an integer list", v)
+		return def
+	}
+	is := make([]int, len(list))
+	for i, item := range list {
+		if is[i], ok = toInt(item); !ok {
+			a.mistyped(key, "an integer list", v)
+			return def
+		}
+	}
+	return is
+}
+
+// Comp reads a nested component, nil when missing
+func (a *Args) Comp(key string) *Comp {
+	v, ok := a.get(key)
+	if !ok {
+		return nil
+	}
+	switch val := v.(type) {
+	case string:
+		return &Comp{Type: val}
+	case map[string]any:
+		args := make(map[string]any, len(val))
+		for k, item := range val {
+			args[k] = item
+		}
+		comp := &Comp{}
+		if err := comp.set(args); err != nil {
+			a.Fail(fmt.Errorf("arg %q: %w", key, err))
+			return nil
+		}
+		return comp
+	}
+	a.mistyped(key, "a component", v)
+	return nil
+}
+
+// Err is every mistyped and unknown arg
+func (a *Args) Err() error {
+	var unknown []string
+	for k := range a.comp.Args {
+		if !a.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		a.Fail(fmt.Errorf("unknown arg %q", k))
+	}
+	if a.err != nil {
+		return fmt.Errorf("%s: %w", a.comp.Type, a.err)
+	}
+	return nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
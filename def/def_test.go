@@ -0,0 +1,170 @@
+package def
+
+import (
+	"pneuma/nn"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const jsonDef = `{
+	"size": [6, 6, 1],
+	"conv": {
+		"blocks": [
+			{"layers": [{"type": "conv", "size": [3, 3, 2], "stride": [1, 1], "pad": "fit"}]}
+		],
+		"layers": ["relu", {"type": "maxpool", "size": [2, 2]}],
+		"optimizer": {"type": "momentum", "lr": 0.01, "mt": 0.5},
+		"initer": {"type": "const", "val": 0.1}
+	},
+	"full": {
+		"size": [4, 3],
+		"layers": ["linear", {"type": "dropout", "rate": 0.2, "seed": 1}],
+		"optimizer": {"type": "adam", "lr": 0.01, "schedule": {"type": "step", "size": 2}, "clip_norm": 5},
+		"initer": {"type": "const", "val": 0.1}
+	},
+	"target": {"type": "ce", "smooth": 0.1}
+}`
+
+const yamlDef = `
+size: [6, 6, 1]
+conv:
+  blocks:
+    - layers:
+        - {type: conv, size: [3, 3, 2], stride: [1, 1], pad: fit}
+  layers: [relu, {type: maxpool, size: [2, 2]}]
+  optimizer: {type: momentum, lr: 0.01, mt: 0.5}
+  initer: {type: const, val: 0.1}
+full:
+  size: [4, 3]
+  layers: [linear, {type: dropout, rate: 0.2, seed: 1}]
+  optimizer:
+    type: adam
+    lr: 0.01
+    schedule: {type: step, size: 2}
+    clip_norm: 5
+  initer: {type: const, val: 0.1}
+target: {type: ce, smooth: 0.1}
+`
+
+func TestBuild(t *testing.T) {
+	jd, err := Parse([]byte(jsonDef))
+	if err != nil {
+		t.Fatalf("parse json: %v", err)
+	}
+	yd, err := ParseYAML([]byte(yamlDef))
+	if err != nil {
+		t.Fatalf("parse yaml: %v", err)
+	}
+	jm, err := Build(jd)
+	if err != nil {
+		t.Fatalf("build json: %v", err)
+	}
+	ym, err := Build(yd)
+	if err != nil {
+		t.Fatalf("build yaml: %v", err)
+	}
+	if jm.LayerCnt() != 3 {
+		t.Fatalf("layer cnt need 3, but %d", jm.LayerCnt())
+	}
+	_, hlayers := jm.Layer(0)
+	if len(hlayers) != 3 {
+		t.Fatalf("conv block need conv, relu and maxpool, but %d hlayers", len(hlayers))
+	}
+	if _, ok := hlayers[2].(interface{ InitSize([]int) []int }); !ok {
+		t.Fatalf("maxpool not built")
+	}
+	opt, _ := jm.Layer(1)
+	if _, ok := opt.(*nn.OptClipNorm); !ok {
+		t.Fatalf("full optimizer need clip norm outside, but %T", opt)
+	}
+	x := mat.NewDense(36, 2, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return float64((i*7+j*3)%5) / 5
+	}, x)
+	y := mat.NewDense(3, 2, []float64{1, 0, 0, 1, 0, 0})
+	for i := 0; i < 3; i++ {
+		jm.Train(x, y)
+		ym.Train(x, y)
+	}
+	if !mat.EqualApprox(jm.Predict(x), ym.Predict(x), 1e-12) {
+		t.Fatalf("json and yaml models diverged")
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		def  string
+		err  string
+	}{
+		{"unknown layer", `{"size": [4], "full": {"size": [2], "layers": ["linearx"], "optimizer": "normal"}, "target": "mse"}`, `unknown layer "linearx"`},
+		{"mistyped arg", `{"size": [4], "full": {"size": [2], "layers": ["linear"], "optimizer": {"type": "normal", "lr": "big"}}, "target": "mse"}`, `arg "lr" need a number`},
+		{"unknown arg", `{"size": [4], "full": {"size": [2], "layers": ["linear"], "optimizer": {"type": "normal", "rl": 0.1}}, "target": "mse"}`, `unknown arg "rl"`},
+		{"no optimizer", `{"size": [4], "full": {"size": [2], "layers": ["linear"]}, "target": "mse"}`, `full block 0 has no optimizer`},
+		{"bad padding", `{"size": [4, 4, 1], "conv": {"blocks": [{"layers": [{"type": "conv", "size": [2, 2, 1], "pad": "same"}]}], "optimizer": "normal"}, "target": "mse"}`, `unknown padding "same"`},
+		{"bad fill", `{"size": [4, 4, 1], "conv": {"blocks": [{"layers": [{"type": "conv", "size": [2, 2, 1], "fill": "wrap"}]}], "optimizer": "normal"}, "target": "mse"}`, `unknown fill "wrap"`},
+		{"bad rate", `{"size": [4], "full": {"size": [2], "layers": ["linear", {"type": "dropout", "rate": 1}], "optimizer": "normal"}, "target": "mse"}`, `dropout rate`},
+		{"zero step", `{"size": [4], "full": {"size": [2], "layers": ["linear"], "optimizer": {"type": "adam", "schedule": {"type": "step", "size": 0}}}, "target": "mse"}`, `arg "size" need positive`},
+		{"zero period", `{"size": [4], "full": {"size": [2], "layers": ["linear"], "optimizer": {"type": "adam", "schedule": {"type": "cosine", "period": 0}}}, "target": "mse"}`, `arg "period" need positive`},
+		{"no target", `{"size": [4], "full": {"size": [2], "layers": ["linear"], "optimizer": "normal"}}`, `target is required`},
+	}
+	for _, c := range cases {
+		d, err := Parse([]byte(c.def))
+		if err != nil {
+			t.Fatalf("%s parse: %v", c.name, err)
+		}
+		_, err = Build(d)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s need error %q, but %v", c.name, c.err, err)
+		}
+	}
+	if _, err := Parse([]byte(`{"size": [4], "ful": {}}`)); err == nil {
+		t.Fatalf("unknown field not rejected")
+	}
+	if _, err := ParseYAML([]byte("size: [4]\nful: {}\n")); err == nil {
+		t.Fatalf("unknown yaml field not rejected")
+	}
+}
+
+func TestBuildFRCNN(t *testing.T) {
+	d, err := ParseYAML([]byte(`
+size: [16, 16, 3]
+conv:
+  blocks:
+    - layers: [{type: conv, size: [3, 3, 4], pad: all}]
+  layers: [relu, {type: maxpool, size: [2, 2]}]
+  optimizer: {type: normal, lr: 0.001}
+rpn:
+  roi: [3, 3, 4]
+  optimizer: {type: normal, lr: 0.001}
+  score_target: {type: focal, alpha: 0.25, gamma: 2}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	m, err := BuildFRCNN(d)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if m.RPN == nil || m.LayerCnt() != 1 {
+		t.Fatalf("frcnn need a conv layer and the rpn")
+	}
+}
+
+func TestNewMerger(t *testing.T) {
+	m, err := Default.NewMerger(&Comp{Type: "concat", Args: map[string]any{"spatial": 4}})
+	if err != nil {
+		t.Fatalf("concat merger: %v", err)
+	}
+	if cat, ok := m.(*nn.MergeConcat); !ok || cat.Spatial != 4 {
+		t.Fatalf("concat merger need spatial 4, but %#v", m)
+	}
+	if _, err := Default.NewMerger(&Comp{Type: "concat", Args: map[string]any{"spatial": 0}}); err == nil || !strings.Contains(err.Error(), `arg "spatial" need positive`) {
+		t.Fatalf("zero spatial need error, but %v", err)
+	}
+	if _, err := Default.NewMerger(&Comp{Type: "stack"}); err == nil || !strings.Contains(err.Error(), `unknown merger "stack"`) {
+		t.Fatalf("unknown merger need error, but %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+package def
+
+import (
+	"fmt"
+	"pneuma/cnn"
+	"pneuma/common"
+	"pneuma/nn"
+)
+
+// Registry maps the type names of a def to constructors
+type Registry struct {
+	layers  map[string]func(a *Args) common.IHLayer
+	kernels map[string]func(a *Args, param cnn.ConvKernalParam) common.IHLayerSizeIniter
+	opts    map[string]func(a *Args) common.IOptimizer
+	scheds  map[string]func(a *Args) nn.LRSchedule
+	tars    map[string]func(a *Args) common.ITarget
+	initers map[string]func(a *Args) common.IInitializer
+	mergers map[string]func(a *Args) common.IMerger
+}
+
+// Default is used by Build and BuildFRCNN, register custom components to it
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		layers:  make(map[string]func(a *Args) common.IHLayer),
+		kernels: make(map[string]func(a *Args, param cnn.ConvKernalParam) common.IHLayerSizeIniter),
+		opts:    make(map[string]func(a *Args) common.IOptimizer),
+		scheds:  make(map[string]func(a *Args) nn.LRSchedule),
+		tars:    make(map[string]func(a *Args) common.ITarget),
+		initers: make(map[string]func(a *Args) common.IInitializer),
+		mergers: make(map[string]func(a *Args) common.IMerger),
+	}
+	regLayers(r)
+	regOpts(r)
+	regTars(r)
+	regIniters(r)
+	regMergers(r)
+	return r
+}
+
+func (r *Registry) Layer(name string, fn func(a *Args) common.IHLayer) {
+	r.layers[name] = fn
+}
+
+// Kernel registers a layer built from the kernel param the rpn works out
+func (r *Registry) Kernel(name string, fn func(a *Args, param cnn.ConvKernalParam) common.IHLayerSizeIniter) {
+	r.kernels[name] = fn
+}
+
+func (r *Registry) Opt(name string, fn func(a *Args) common.IOptimizer) {
+	r.opts[name] = fn
+}
+
+func (r *Registry) Sched(name string, fn func(a *Args) nn.LRSchedule) {
+	r.scheds[name] = fn
+}
+
+func (r *Registry) Tar(name string, fn func(a *Args) common.ITarget) {
+	r.tars[name] = fn
+}
+
+func (r *Registry) Init(name string, fn func(a *Args) common.IInitializer) {
+	r.initers[name] = fn
+}
+
+// Merger registers a merge of the graph nodes
+func (r *Registry) Merger(name string, fn func(a *Args) common.IMerger) {
+	r.mergers[name] = fn
+}
+
+func unknownType(kind, name string) error {
+	return fmt.Errorf("unknown %s %q", kind, name)
+}
+
+func (r *Registry) NewLayer(c *Comp) (common.IHLayer, error) {
+	fn, ok := r.layers[c.Type]
+	if !ok {
+		return nil, unknownType("layer", c.Type)
+	}
+	a := newArgs(c, r)
+	l := fn(a)
+	return l, a.Err()
+}
+
+func (r *Registry) NewKernel(c *Comp, param cnn.ConvKernalParam) (common.IHLayerSizeIniter, error) {
+	fn, ok := r.kernels[c.Type]
+	if !ok {
+		return nil, unknownType("kernel layer", c.Type)
+	}
+	a := newArgs(c, r)
+	l := fn(a, param)
+	return l, a.Err()
+}
+
+// NewOpt builds the optimizer, then wraps it by the shared args,
+// schedule and by_epoch, l1 and l2, clip_value, clip_norm
+func (r *Registry) NewOpt(c *Comp) (common.IOptimizer, error) {
+	fn, ok := r.opts[c.Type]
+	if !ok {
+		return nil, unknownType("optimizer", c.Type)
+	}
+	a := newArgs(c, r)
+	opt := fn(a)
+	if sched := a.Sched("schedule"); sched != nil {
+		byEpoch := a.Bool("by_epoch", true)
+		if lr, ok := opt.(common.IOptimizerLR); ok && nn.HasLR(opt) {
+			opt = nn.NewOptSchedule(lr, sched, byEpoch)
+		} else {
+			a.Fail(fmt.Errorf("optimizer has no learning rate to schedule"))
+		}
+	}
+	if l1, l2 := a.Float("l1", 0), a.Float("l2", 0); l1 != 0 || l2 != 0 {
+		opt = nn.NewOptWeightDecay(opt, l1, l2)
+	}
+	if max := a.Float("clip_value", 0); max > 0 {
+		opt = nn.NewOptClipValue(opt, max)
+	}
+	if max := a.Float("clip_norm", 0); max > 0 {
+		opt = nn.NewOptClipNorm(opt, max)
+	}
+	return opt, a.Err()
+}
+
+func (r *Registry) NewSched(c *Comp) (nn.LRSchedule, error) {
+	fn, ok := r.scheds[c.Type]
+	if !ok {
+		return nil, unknownType("schedule", c.Type)
+	}
+	a := newArgs(c, r)
+	s := fn(a)
+	return s, a.Err()
+}
+
+func (r *Registry) NewTar(c *Comp) (common.ITarget, error) {
+	fn, ok := r.tars[c.Type]
+	if !ok {
+		return nil, unknownType("target", c.Type)
+	}
+	a := newArgs(c, r)
+	t := fn(a)
+	return t, a.Err()
+}
+
+func (r *Registry) NewIniter(c *Comp) (common.IInitializer, error) {
+	fn, ok := r.initers[c.Type]
+	if !ok {
+		return nil, unknownType("initer", c.Type)
+	}
+	a := newArgs(c, r)
+	i := fn(a)
+	return i, a.Err()
+}
+
+func (r *Registry) NewMerger(c *Comp) (common.IMerger, error) {
+	fn, ok := r.mergers[c.Type]
+	if !ok {
+		return nil, unknownType("merger", c.Type)
+	}
+	a := newArgs(c, r)
+	m := fn(a)
+	return m, a.Err()
+}
+
+// Sched reads a nested schedule, nil when missing
+func (a *Args) Sched(key string) nn.LRSchedule {
+	c := a.Comp(key)
+	if c == nil {
+		return nil
+	}
+	s, err := a.reg.NewSched(c)
+	if err != nil {
+		a.Fail(fmt.Errorf("arg %q: %w", key, err))
+		return nil
+	}
+	return s
+}
+
+// Kernel reads size, stride, pad, pads, fill, value and dilation, pad is one of no, fit and all,
+// pads are the left and right amounts of every spatial dim in turn, taking over pad,
+// fill is one of zero, const, reflect and replicate, value is the constant
+func (a *Args) Kernel(stride []int) cnn.ConvKernalParam {
+	size := a.Ints("size", nil)
+	if len(size) == 0 {
+		a.Fail(fmt.Errorf("arg %q is required", "size"))
+	}
+	pad, err := ParsePadding(a.String("pad", "fit"))
+	if err != nil {
+		a.Fail(err)
+	}
+	param := cnn.NewConvKParam(size, a.Ints("stride", stride), pad)
+	if pads := a.Ints("pads", nil); pads != nil {
+		if len(pads)%2 != 0 {
+			a.Fail(fmt.Errorf("arg %q need left and right of every dim, but %v", "pads", pads))
+		}
+		pairs := make([][2]int, len(pads)/2)
+		for i := range pairs {
+			pairs[i] = [2]int{pads[2*i], pads[2*i+1]}
+		}
+		param = param.Pads(pairs)
+	}
+	fill, err := ParseFill(a.String("fill", "zero"))
+	if err != nil {
+		a.Fail(err)
+	}
+	param = param.Fill(fill, a.Float("value", 0))
+	if dilation := a.Ints("dilation", nil); dilation != nil {
+		param = param.Dilate(dilation)
+	}
+	return param
+}
+
+func ParsePadding(s string) (cnn.ConvKernalPadding, error) {
+	switch s {
+	case "no":
+		return cnn.ConvKernalPadNo, nil
+	case "fit":
+		return cnn.ConvKernalPadFit, nil
+	case "all":
+		return cnn.ConvKernalPadAll, nil
+	}
+	return 0, fmt.Errorf("unknown padding %q, need no, fit or all", s)
+}
+
+func ParseFill(s string) (cnn.ConvKernalFill, error) {
+	switch s {
+	case "zero":
+		return cnn.ConvKernalFillZero, nil
+	case "const":
+		return cnn.ConvKernalFillConst, nil
+	case "reflect":
+		return cnn.ConvKernalFillReflect, nil
+	case "replicate":
+		return cnn.ConvKernalFillReplicate, nil
+	}
+	return 0, fmt.Errorf("unknown fill %q, need zero, const, reflect or replicate", s)
+}
+
+func ones(n int) []int {
+	if n < 0 {
+		n = 0
+	}
+	ret := make([]int, n)
+	for i := range ret {
+		ret[i] = 1
+	}
+	return ret
+}
+
+func regLayers(r *Registry) {
+	r.Layer("linear", func(a *Args) common.IHLayer { return nn.NewHLayerLinear() })
+	r.Layer("batchnorm", func(a *Args) common.IHLayer {
+		return nn.NewHLayerBatchNorm(a.Float("minstd", 0.0001), a.Float("momentum", 0.9))
+	})
+	r.Layer("layernorm", func(a *Args) common.IHLayer { return nn.NewHLayerLayerNorm(a.Float("minstd", 0.0001)) })
+	r.Layer("sigmoid", func(a *Args) common.IHLayer { return nn.NewHLayerSigmoid() })
+	r.Layer("tanh", func(a *Args) common.IHLayer { return nn.NewHLayerTanh() })
+	r.Layer("relu", func(a *Args) common.IHLayer { return nn.NewHLayerRelu() })
+	r.Layer("leakyrelu", func(a *Args) common.IHLayer { return nn.NewHLayerLeakyRelu(a.Float("slope", 0.01)) })
+	r.Layer("prelu", func(a *Args) common.IHLayer { return nn.NewHLayerPRelu(a.Float("init", 0.25)) })
+	r.Layer("elu", func(a *Args) common.IHLayer { return nn.NewHLayerElu(a.Float("alpha", 1)) })
+	r.Layer("gelu", func(a *Args) common.IHLayer { return nn.NewHLayerGelu() })
+	r.Layer("softplus", func(a *Args) common.IHLayer { return nn.NewHLayerSoftplus() })
+	r.Layer("swish", func(a *Args) common.IHLayer { return nn.NewHLayerSwish(a.Float("beta", 1)) })
+	r.Layer("softmax", func(a *Args) common.IHLayer { return nn.NewHLayerSoftmax() })
+	r.Layer("dropout", func(a *Args) common.IHLayer {
+		return nn.NewHLayerDropout(rate(a), int64(a.Int("seed", 0)))
+	})
+	r.Layer("conv", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerConv(groups(a, a.Kernel(ones(len(a.Ints("size", nil))-1))))
+	})
+	r.Layer("separable_conv", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerSeparableConv(a.Kernel(ones(len(a.Ints("size", nil)) - 1)))
+	})
+	r.Layer("conv_transpose", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerConvTranspose(a.Kernel(ones(len(a.Ints("size", nil)) - 1)))
+	})
+	r.Layer("upsample", func(a *Args) common.IHLayer {
+		scale := a.Ints("scale", nil)
+		if len(scale) == 0 {
+			a.Fail(fmt.Errorf("arg %q is required", "scale"))
+		}
+		switch mode := a.String("mode", "nearest"); mode {
+		case "nearest":
+			return cnn.NewHLayerUpsamplingNearest(scale)
+		case "bilinear":
+			return cnn.NewHLayerUpsamplingBilinear(scale)
+		default:
+			a.Fail(fmt.Errorf("unknown upsample mode %q, need nearest or bilinear", mode))
+		}
+		return nil
+	})
+	r.Layer("conv_batchnorm", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerConvBatchNorm(a.Float("minstd", 0.0001), a.Float("momentum", 0.9))
+	})
+	r.Layer("spatial_dropout", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerSpatialDropout(rate(a), int64(a.Int("seed", 0)))
+	})
+	r.Layer("maxpool", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerMaxPooling(a.Kernel(a.Ints("size", nil)))
+	})
+	r.Layer("avgpool", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerAvgPooling(a.Kernel(a.Ints("size", nil)))
+	})
+	r.Layer("global_avgpool", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerGlobalAvgPooling()
+	})
+	r.Layer("global_maxpool", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerGlobalMaxPooling()
+	})
+	r.Layer("reshape", func(a *Args) common.IHLayer {
+		size := a.Ints("size", nil)
+		if len(size) == 0 {
+			a.Fail(fmt.Errorf("arg %q is required", "size"))
+		}
+		return cnn.NewHLayerReshape(size)
+	})
+	r.Layer("flatten", func(a *Args) common.IHLayer { return cnn.NewHLayerFlatten() })
+	r.Layer("squeeze", func(a *Args) common.IHLayer { return cnn.NewHLayerSqueeze(a.Ints("dims", nil)...) })
+	r.Layer("unsqueeze", func(a *Args) common.IHLayer { return cnn.NewHLayerUnsqueeze(a.Int("dim", 0)) })
+	r.Layer("permute", func(a *Args) common.IHLayer {
+		order := a.Ints("order", nil)
+		if len(order) == 0 {
+			a.Fail(fmt.Errorf("arg %q is required", "order"))
+		}
+		return cnn.NewHLayerPermute(order)
+	})
+	r.Layer("groupnorm", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerGroupNorm(a.Int("groups", 0), a.Float("minstd", 0.0001))
+	})
+	r.Layer("instancenorm", func(a *Args) common.IHLayer {
+		return cnn.NewHLayerInstanceNorm(a.Float("minstd", 0.0001))
+	})
+	r.Kernel("conv", func(a *Args, param cnn.ConvKernalParam) common.IHLayerSizeIniter {
+		if dilation := a.Ints("dilation", nil); dilation != nil {
+			param = param.Dilate(dilation)
+		}
+		return cnn.NewHLayerConv(groups(a, param))
+	})
+}
+
+// the conv constructors panic on a non positive group count
+func groups(a *Args, param cnn.ConvKernalParam) cnn.ConvKernalParam {
+	cnt := a.Int("groups", 1)
+	if cnt <= 0 {
+		a.Fail(fmt.Errorf("conv groups need positive, but %d", cnt))
+		return param
+	}
+	return param.Group(cnt)
+}
+
+// the dropout constructors panic out of [0, 1)
+func rate(a *Args) float64 {
+	rate := a.Float("rate", 0.5)
+	if rate < 0 || rate >= 1 {
+		a.Fail(fmt.Errorf("dropout rate need in [0, 1), but %f", rate))
+		return 0
+	}
+	return rate
+}
+
+// the step and cosine schedules and the concat merge panic on a non positive arg
+func positive(a *Args, key string, def int) int {
+	v := a.Int(key, def)
+	if v <= 0 {
+		a.Fail(fmt.Errorf("arg %q need positive, but %d", key, v))
+		return def
+	}
+	return v
+}
+
+func regOpts(r *Registry) {
+	r.Opt("normal", func(a *Args) common.IOptimizer { return nn.NewOptNormal(a.Float("lr", 0.001)) })
+	r.Opt("momentum", func(a *Args) common.IOptimizer {
+		return nn.NewOptMomentum(a.Float("lr", 0.001), a.Float("mt", 0.9))
+	})
+	r.Opt("adam", func(a *Args) common.IOptimizer {
+		return nn.NewOptAdam(a.Float("lr", 0.001), a.Float("beta1", 0.9), a.Float("beta2", 0.999), a.Float("eps", 1e-8))
+	})
+	r.Opt("adamw", func(a *Args) common.IOptimizer {
+		return nn.NewOptAdamW(a.Float("lr", 0.001), a.Float("beta1", 0.9), a.Float("beta2", 0.999), a.Float("eps", 1e-8), a.Float("decay", 0.01))
+	})
+	r.Opt("rmsprop", func(a *Args) common.IOptimizer {
+		return nn.NewOptRMSProp(a.Float("lr", 0.001), a.Float("rho", 0.9), a.Float("eps", 1e-8))
+	})
+	r.Opt("adagrad", func(a *Args) common.IOptimizer {
+		return nn.NewOptAdagrad(a.Float("lr", 0.01), a.Float("eps", 1e-8))
+	})
+	r.Sched("step", func(a *Args) nn.LRSchedule { return nn.NewLRStep(positive(a, "size", 1), a.Float("gamma", 0.1)) })
+	r.Sched("exp", func(a *Args) nn.LRSchedule { return nn.NewLRExp(a.Float("gamma", 0.9)) })
+	r.Sched("cosine", func(a *Args) nn.LRSchedule {
+		return nn.NewLRCosine(positive(a, "period", 10), a.Int("mult", 1), a.Float("min_lr", 0))
+	})
+	r.Sched("warmup", func(a *Args) nn.LRSchedule { return nn.NewLRWarmup(a.Int("steps", 1), a.Sched("after")) })
+	r.Sched("plateau", func(a *Args) nn.LRSchedule {
+		return nn.NewLRPlateau(a.Float("factor", 0.5), a.Int("patience", 1), a.Float("threshold", 0.0001), a.Float("min_lr", 0))
+	})
+}
+
+func regTars(r *Registry) {
+	r.Tar("ce", func(a *Args) common.ITarget {
+		weights := a.Floats("weights", nil)
+		smooth := a.Float("smooth", 0)
+		if smooth < 0 || smooth >= 1 {
+			a.Fail(fmt.Errorf("label smoothing need in [0, 1), but %f", smooth))
+			smooth = 0
+		}
+		return nn.NewTarWeightedCE(weights, smooth)
+	})
+	r.Tar("mse", func(a *Args) common.ITarget { return nn.NewTarMSE() })
+	r.Tar("mae", func(a *Args) common.ITarget { return nn.NewTarMAE() })
+	r.Tar("smooth_mae", func(a *Args) common.ITarget { return nn.NewTarSmoothMAE(a.Float("beta", 1)) })
+	r.Tar("bce", func(a *Args) common.ITarget { return nn.NewTarBCE() })
+	r.Tar("focal", func(a *Args) common.ITarget { return nn.NewTarFocal(a.Float("alpha", 0.25), a.Float("gamma", 2)) })
+	r.Tar("huber", func(a *Args) common.ITarget { return nn.NewTarHuber(a.Float("delta", 1)) })
+	r.Tar("hinge", func(a *Args) common.ITarget { return nn.NewTarHinge(a.Float("margin", 1)) })
+	r.Tar("kl", func(a *Args) common.ITarget { return nn.NewTarKL() })
+	r.Tar("cosine", func(a *Args) common.ITarget { return nn.NewTarCosine(a.Float("margin", 0)) })
+}
+
+func regIniters(r *Registry) {
+	r.Init("rand", func(a *Args) common.IInitializer { return common.NewInitRand() })
+	r.Init("const", func(a *Args) common.IInitializer { return common.NewInitConst(a.Float("val", 0)) })
+	r.Init("xavier", func(a *Args) common.IInitializer { return common.NewInitXavier(a.Bool("normal", false)) })
+	r.Init("he", func(a *Args) common.IInitializer { return common.NewInitHe(a.Bool("normal", false)) })
+	r.Init("lecun", func(a *Args) common.IInitializer { return common.NewInitLeCun(a.Bool("normal", false)) })
+	r.Init("orthogonal", func(a *Args) common.IInitializer { return common.NewInitOrthogonal(a.Float("gain", 1)) })
+	r.Init("var_scaling", func(a *Args) common.IInitializer {
+		var mode common.FanMode
+		switch m := a.String("mode", "in"); m {
+		case "in":
+			mode = common.FanIn
+		case "out":
+			mode = common.FanOut
+		case "avg":
+			mode = common.FanAvg
+		default:
+			a.Fail(fmt.Errorf("unknown fan mode %q, need in, out or avg", m))
+		}
+		return common.NewInitVarScaling(a.Float("scale", 1), mode, a.Bool("normal", false))
+	})
+}
+
+// concat joins the channels of the inputs, the channel concat of conv sections
+func regMergers(r *Registry) {
+	r.Merger("add", func(a *Args) common.IMerger { return nn.NewMergeAdd() })
+	r.Merger("concat", func(a *Args) common.IMerger { return nn.NewMergeConcat(positive(a, "spatial", 1)) })
+}
@@ -25,17 +25,21 @@ func (m *ModelBuilder) Target(tar func() common.ITarget) {
 
 func (m *ModelBuilder) Build() *nn.Model {
 	model := nn.NewModel()
+	model.SetInputSize(m.size[:1])
 	for i := 0; i < len(m.size)-1; i++ {
 		c := m.size[i]
 		r := m.size[i+1]
 
 		opt := m.Optimizer()
 		lay := nn.NewHLayerLinear()
-		lay.InitSize([]int{r, c})
 		hlayers := []common.IHLayer{lay}
 		for _, hlayer := range m.Lays {
 			hlayers = append(hlayers, hlayer())
 		}
+		if m.Initer != nil {
+			common.SetIniter(m.Initer(), hlayers...)
+		}
+		lay.InitSize([]int{r, c})
 		model.AddLayer(
 			opt,
 			hlayers...,
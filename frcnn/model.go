@@ -1,6 +1,8 @@
 package frcnn
 
 import (
+	"errors"
+	"io"
 	"pneuma/cnn"
 	"pneuma/common"
 	"pneuma/nn"
@@ -13,8 +15,9 @@ type ModelBuilder struct {
 	roiSize []int
 	c       *cnn.ModelSizeBuilder
 	*nn.ModelBuilder
-	model *Model
-	rpn   func(score, trans cnn.ConvKernalParam) (scnv, tcnv common.IHLayerSizeIniter, opt common.IOptimizer)
+	model    *Model
+	rpn      func(score, trans cnn.ConvKernalParam) (scnv, tcnv common.IHLayerSizeIniter, opt common.IOptimizer)
+	scoreTar common.ITarget
 }
 
 func NewModelBuilder(size, roiSize []int) *ModelBuilder {
@@ -44,12 +47,21 @@ func (b *ModelBuilder) COpt(l func() common.IOptimizer) {
 	b.c.Opt(l)
 }
 
+func (b *ModelBuilder) CInit(i func() common.IInitializer) {
+	b.c.Init(i)
+}
+
 func (b *ModelBuilder) RPN(l func(score, trans cnn.ConvKernalParam) (scnv, tcnv common.IHLayerSizeIniter, opt common.IOptimizer)) {
 	b.rpn = l
 }
 
+func (b *ModelBuilder) RPNScoreTar(tar common.ITarget) {
+	b.scoreTar = tar
+}
+
 func (b *ModelBuilder) Build() *Model {
 	m := b.model
+	m.SetInputSize(b.c.Size)
 	csize := b.c.Bulld(m.Model)
 	if b.rpn != nil {
 		param := NewRPNParam(b.c.Size, b.roiSize)
@@ -59,6 +71,9 @@ func (b *ModelBuilder) Build() *Model {
 		m.RPN.SetTransLayer(tconv)
 		m.RPN.SetOpt(opt)
 		m.RPN.InitSize(csize)
+		if b.scoreTar != nil {
+			m.RPN.SetScoreTarget(b.scoreTar)
+		}
 	}
 	return m
 }
@@ -75,9 +90,29 @@ func NewModel() *Model {
 	return ret
 }
 
+// Summary appends the rpn convs, both take the output of the last layer
+func (m *Model) Summary() *nn.Summary {
+	s := m.Model.Summary()
+	if m.RPN == nil {
+		return s
+	}
+	size := m.InputSize()
+	if len(s.Rows) > 0 {
+		size = s.Rows[len(s.Rows)-1].Out
+	}
+	s.Add(m.LayerCnt(), m.RPN.convScores, size)
+	s.Add(m.LayerCnt(), m.RPN.convTransf, size)
+	return s
+}
+
+// a score target set on the former rpn is kept by the new one
 func (m *Model) UseRPN(param *RPNParam) *RPN {
+	old := m.RPN
 	m.RPN = NewRPN(param)
 	m.RPN.SetTrsTarget(nn.NewTarSmoothMAE(0.5), NewRPNLossParam())
+	if old != nil && old.scoreTar != nil {
+		m.RPN.SetScoreTarget(old.scoreTar)
+	}
 	return m.RPN
 }
 
@@ -139,6 +174,16 @@ func (m *Model) TrainTimes(trainX, trainY []*mat.Dense, oneTimes func(int, int))
 	}, oneTimes)
 }
 
+func (m *Model) EpochEnd(loss float64) {
+	m.Model.EpochEnd(loss)
+	if m.RPN == nil {
+		return
+	}
+	if epoch, ok := m.RPN.opt.(common.IOptimizerEpoch); ok {
+		epoch.EpochEnd(loss)
+	}
+}
+
 func (m *Model) IsDone() bool {
 	if m.RPN != nil {
 		return m.RPN.loss.isDone()
@@ -164,3 +209,34 @@ func (m *Model) LossLatest() float64 {
 	}
 	return 0
 }
+
+func (m *Model) Save(w io.Writer) error {
+	err := m.Model.Save(w)
+	if err != nil {
+		return err
+	}
+	if m.RPN == nil {
+		return nn.SaveHLayers(w, nil)
+	}
+	return nn.SaveHLayers(w, m.RPN.opt, m.RPN.convScores, m.RPN.convTransf)
+}
+
+func (m *Model) Load(r io.Reader) error {
+	return Load(r, m)
+}
+
+func Load(r io.Reader, m *Model) error {
+	err := nn.Load(r, m.Model)
+	if err != nil {
+		return err
+	}
+	if m.RPN == nil {
+		err = nn.LoadHLayers(r, nil)
+	} else {
+		err = nn.LoadHLayers(r, m.RPN.opt, m.RPN.convScores, m.RPN.convTransf)
+	}
+	if err != nil {
+		return errors.Join(errors.New("load rpn"), err)
+	}
+	return nil
+}
@@ -53,6 +53,7 @@ type RPN struct {
 	PropInners []bool
 	PropBounds *Bounds
 	opt        common.IOptimizer
+	scoreTar   common.ITarget
 }
 
 func NewRPN(param *RPNParam) *RPN {
@@ -72,10 +73,25 @@ func (l *RPN) SetOpt(opt common.IOptimizer) {
 
 func (l *RPN) SetTrsTarget(tar common.ITarget, param *RPNLossParam) {
 	l.loss = newRPNTrsLoss(tar, param)
+	l.keepScoreTarget()
 }
 
 func (l *RPN) SetBndTarget(tar common.ITarget, param *RPNLossParam) {
 	l.loss = newRPNBndLoss(tar, param)
+	l.keepScoreTarget()
+}
+
+// scores are flatten to 2 rows of pos and neg, cross entropy by default,
+// the target stays when the trans or bound target is set again
+func (l *RPN) SetScoreTarget(tar common.ITarget) {
+	l.scoreTar = tar
+	l.keepScoreTarget()
+}
+
+func (l *RPN) keepScoreTarget() {
+	if l.scoreTar != nil {
+		l.loss.score = l.scoreTar
+	}
 }
 
 func (l *RPN) ScoreLayerParam() cnn.ConvKernalParam {
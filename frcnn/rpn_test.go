@@ -0,0 +1,68 @@
+package frcnn
+
+import (
+	"pneuma/common"
+	"pneuma/gradcheck"
+	"pneuma/nn"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// rpnHead stacks the scores over the transforms, so both convs of the head
+// are checked against one input
+type rpnHead struct {
+	*RPN
+	scoreRows int
+}
+
+func (l *rpnHead) Forward(x *mat.Dense) (y *mat.Dense) {
+	scores, transf := l.forward(x)
+	sr, c := scores.Dims()
+	tr, _ := transf.Dims()
+	l.scoreRows = sr
+	y = mat.NewDense(sr+tr, c, nil)
+	y.Slice(0, sr, 0, c).(*mat.Dense).Copy(scores)
+	y.Slice(sr, sr+tr, 0, c).(*mat.Dense).Copy(transf)
+	return
+}
+
+func (l *rpnHead) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dScores := mat.DenseCopyOf(dy.Slice(0, l.scoreRows, 0, c))
+	dTransf := mat.DenseCopyOf(dy.Slice(l.scoreRows, r, 0, c))
+	return l.backward(dScores, dTransf)
+}
+
+func (l *rpnHead) Optimize() (datas, deltas []mat.Matrix) {
+	return common.OptimizeData(l.convScores, l.convTransf)
+}
+
+func TestRPNHeadGrad(t *testing.T) {
+	rpn := NewRPN(NewRPNParam([]int{8, 8, 3}, []int{2, 2, 3}))
+	rpn.InitSize([]int{4, 4, 2})
+	x := gradcheck.RandDense(4*4*2, 2, 1)
+	results, err := gradcheck.Layer(&rpnHead{RPN: rpn}, x, 1e-5)
+	if err != nil {
+		t.Fatalf("rpn head check failed: %v", err)
+	}
+	for _, r := range results {
+		if r.RelErr > 1e-5 {
+			t.Errorf("rpn head gradient wrong: %v", r)
+		}
+	}
+}
+
+func TestRPNScoreTarget(t *testing.T) {
+	m := NewModel()
+	focal := nn.NewTarFocal(0.25, 2)
+	m.UseRPN(NewRPNParam([]int{8, 8, 3}, []int{2, 2, 3})).SetScoreTarget(focal)
+	m.RPN.SetTrsTarget(nn.NewTarSmoothMAE(0.5), NewRPNLossParam())
+	if m.RPN.loss.score != focal {
+		t.Errorf("score target lost by SetTrsTarget")
+	}
+	m.UseRPN(NewRPNParam([]int{8, 8, 3}, []int{2, 2, 3}))
+	if m.RPN.loss.score != focal {
+		t.Errorf("score target lost by UseRPN")
+	}
+}
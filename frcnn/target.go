@@ -22,7 +22,7 @@ func NewRPNLossParam() *RPNLossParam {
 
 type rpnLoss struct {
 	losses []float64
-	score  *nn.TargetCE
+	score  common.ITarget
 	trans  common.ITarget
 	bnds   common.ITarget
 	param  *RPNLossParam
@@ -0,0 +1,176 @@
+package gradcheck
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// errors smaller than relFloor are compared absolutely, so gradients that
+// are exactly zero do not blow up the relative error
+const relFloor = 1e-4
+
+type Result struct {
+	Name     string
+	Idx      int
+	RelErr   float64
+	Analytic float64
+	Numeric  float64
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s[%d] relerr:%.3g analytic:%.6g numeric:%.6g", r.Name, r.Idx, r.RelErr, r.Analytic, r.Numeric)
+}
+
+func Worst(results []Result) (worst Result) {
+	for i, r := range results {
+		if i == 0 || r.RelErr > worst.RelErr {
+			worst = r
+		}
+	}
+	return
+}
+
+type seqReseter interface {
+	SeqReset()
+}
+
+func Sized(l common.IHLayerSizeIniter, size []int, x *mat.Dense, eps float64) ([]Result, error) {
+	l.InitSize(size)
+	return Layer(l, x, eps)
+}
+
+func Layer(l common.IHLayer, x *mat.Dense, eps float64) ([]Result, error) {
+	reseter, _ := l.(seqReseter)
+	forward := func() *mat.Dense {
+		if reseter != nil {
+			reseter.SeqReset()
+		}
+		return l.Forward(x)
+	}
+	y := forward()
+	yr, yc := y.Dims()
+	dy := RandDense(yr, yc, 1)
+	dx := l.Backward(dy)
+	if dx == nil {
+		return nil, errors.New("backward return nil")
+	}
+	if !sameDims(dx, x) {
+		return nil, fmt.Errorf("dx dims not equal to x, dx:%v, x:%v", dimsOf(dx), dimsOf(x))
+	}
+	dx = mat.DenseCopyOf(dx)
+	datas, deltas := common.OptimizeData(l)
+	deltaCopies := make([]mat.Matrix, len(deltas))
+	for k, delta := range deltas {
+		if isNil(delta) {
+			return nil, fmt.Errorf("delta %d is nil", k)
+		}
+		if !sameDims(delta, datas[k]) {
+			return nil, fmt.Errorf("delta %d dims not equal to data, delta:%v, data:%v", k, dimsOf(delta), dimsOf(datas[k]))
+		}
+		deltaCopies[k] = mat.DenseCopyOf(delta)
+	}
+	loss := func() float64 {
+		return dot(forward(), dy)
+	}
+	results := []Result{check("x", x, dx, eps, loss)}
+	for k, data := range datas {
+		results = append(results, check(fmt.Sprintf("data%d", k), data, deltaCopies[k], eps, loss))
+	}
+	return results, nil
+}
+
+// the gradient of a target is the one of its summed LossEach
+func Target(t common.ITarget, pred, targ *mat.Dense, eps float64) (Result, error) {
+	t.Loss(pred, targ)
+	dpred := t.Backward()
+	if dpred == nil {
+		return Result{}, errors.New("backward return nil")
+	}
+	if !sameDims(dpred, pred) {
+		return Result{}, fmt.Errorf("dpred dims not equal to pred, dpred:%v, pred:%v", dimsOf(dpred), dimsOf(pred))
+	}
+	dpred = mat.DenseCopyOf(dpred)
+	return check("pred", pred, dpred, eps, func() float64 {
+		return mat.Sum(t.LossEach(pred, targ))
+	}), nil
+}
+
+func check(name string, data, grad mat.Matrix, eps float64, loss func() float64) (worst Result) {
+	worst.Name = name
+	worst.Idx = -1
+	r, c := data.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			org := data.At(i, j)
+			set(data, i, j, org+eps)
+			lossAdd := loss()
+			set(data, i, j, org-eps)
+			lossSub := loss()
+			set(data, i, j, org)
+			numeric := (lossAdd - lossSub) / (2 * eps)
+			analytic := grad.At(i, j)
+			relErr := math.Abs(analytic-numeric) / math.Max(math.Abs(analytic)+math.Abs(numeric), relFloor)
+			if worst.Idx < 0 || relErr > worst.RelErr {
+				worst.Idx = i*c + j
+				worst.RelErr = relErr
+				worst.Analytic = analytic
+				worst.Numeric = numeric
+			}
+		}
+	}
+	return
+}
+
+func set(m mat.Matrix, i, j int, v float64) {
+	switch rm := m.(type) {
+	case *mat.Dense:
+		rm.Set(i, j, v)
+	case *mat.VecDense:
+		rm.SetVec(i, v)
+	default:
+		panic(fmt.Sprintf("gradcheck not support matrix type %T", m))
+	}
+}
+
+func isNil(m mat.Matrix) bool {
+	switch rm := m.(type) {
+	case *mat.Dense:
+		return rm == nil
+	case *mat.VecDense:
+		return rm == nil
+	}
+	return m == nil
+}
+
+func dimsOf(m mat.Matrix) []int {
+	r, c := m.Dims()
+	return []int{r, c}
+}
+
+func sameDims(a, b mat.Matrix) bool {
+	return common.IntsEqual(dimsOf(a), dimsOf(b))
+}
+
+func dot(a, b *mat.Dense) (ret float64) {
+	r, c := a.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			ret += a.At(i, j) * b.At(i, j)
+		}
+	}
+	return
+}
+
+func RandDense(r, c int, seed int64) *mat.Dense {
+	rnd := rand.New(rand.NewSource(seed))
+	ret := mat.NewDense(r, c, nil)
+	ret.Apply(func(i, j int, v float64) float64 {
+		return rnd.Float64()*2 - 1
+	}, ret)
+	return ret
+}
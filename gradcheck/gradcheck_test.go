@@ -0,0 +1,241 @@
+package gradcheck
+
+import (
+	"math"
+	"pneuma/cnn"
+	"pneuma/common"
+	"pneuma/nn"
+	"pneuma/rnn"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	checkEps = 1e-5
+	checkTol = 1e-5
+)
+
+type seqHLayer interface {
+	common.IHLayerSizeIniter
+	Optimize() (datas, deltas []mat.Matrix)
+	SeqReset()
+}
+
+// seqLayer runs the columns of x as steps of batch columns, so the check covers
+// the gradients through time
+type seqLayer struct {
+	seqHLayer
+	batch int
+}
+
+func (l *seqLayer) Forward(x *mat.Dense) (y *mat.Dense) {
+	xr, xc := x.Dims()
+	for t := 0; t < xc/l.batch; t++ {
+		yt := l.seqHLayer.Forward(x.Slice(0, xr, t*l.batch, (t+1)*l.batch).(*mat.Dense))
+		if y == nil {
+			yr, _ := yt.Dims()
+			y = mat.NewDense(yr, xc, nil)
+		}
+		y.Slice(0, yt.RawMatrix().Rows, t*l.batch, (t+1)*l.batch).(*mat.Dense).Copy(yt)
+	}
+	return
+}
+
+func (l *seqLayer) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	dyr, dyc := dy.Dims()
+	for t := dyc/l.batch - 1; t >= 0; t-- {
+		dxt := l.seqHLayer.Backward(dy.Slice(0, dyr, t*l.batch, (t+1)*l.batch).(*mat.Dense))
+		if dx == nil {
+			dxr, _ := dxt.Dims()
+			dx = mat.NewDense(dxr, dyc, nil)
+		}
+		dx.Slice(0, dxt.RawMatrix().Rows, t*l.batch, (t+1)*l.batch).(*mat.Dense).Copy(dxt)
+	}
+	return
+}
+
+func TestLayers(t *testing.T) {
+	cases := []struct {
+		name  string
+		layer func() common.IHLayer
+		size  []int
+		rows  int
+		batch int
+	}{
+		{"linear", func() common.IHLayer { return nn.NewHLayerLinear() }, []int{3, 4}, 4, 5},
+		{"batchnorm", func() common.IHLayer { return nn.NewHLayerBatchNorm(0.0001, 0.9) }, []int{4, 5}, 4, 5},
+		{"sigmoid", func() common.IHLayer { return nn.NewHLayerSigmoid() }, nil, 4, 3},
+		{"tanh", func() common.IHLayer { return nn.NewHLayerTanh() }, nil, 4, 3},
+		{"relu", func() common.IHLayer { return nn.NewHLayerRelu() }, nil, 4, 3},
+		{"leakyrelu", func() common.IHLayer { return nn.NewHLayerLeakyRelu(0.1) }, nil, 4, 3},
+		{"prelu", func() common.IHLayer { return nn.NewHLayerPRelu(0.25) }, []int{4, 4}, 4, 3},
+		{"prelu_conv", func() common.IHLayer { return nn.NewHLayerPRelu(0.25) }, []int{2, 2, 3}, 12, 3},
+		{"elu", func() common.IHLayer { return nn.NewHLayerElu(1) }, nil, 4, 3},
+		{"gelu", func() common.IHLayer { return nn.NewHLayerGelu() }, nil, 4, 3},
+		{"softplus", func() common.IHLayer { return nn.NewHLayerSoftplus() }, nil, 4, 3},
+		{"swish", func() common.IHLayer { return nn.NewHLayerSwish(1.5) }, nil, 4, 3},
+		{"softmax", func() common.IHLayer { return nn.NewHLayerSoftmax() }, nil, 4, 3},
+		{"conv", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{2, 2, 3}, []int{1, 1}, cnn.ConvKernalPadNo))
+		}, []int{4, 3, 2}, 24, 2},
+		{"conv_padall", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{3, 3, 2}, []int{1, 1}, cnn.ConvKernalPadAll))
+		}, []int{3, 3, 2}, 18, 2},
+		{"conv_reflect", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{3, 2, 2}, []int{1, 1}, cnn.ConvKernalPadNo).
+				Pads([][2]int{{2, 1}, {0, 1}}).Fill(cnn.ConvKernalFillReflect, 0))
+		}, []int{3, 3, 2}, 18, 2},
+		{"conv_replicate", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{3, 3, 2}, []int{2, 1}, cnn.ConvKernalPadAll).Fill(cnn.ConvKernalFillReplicate, 0))
+		}, []int{4, 3, 2}, 24, 2},
+		{"conv_dilated", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{2, 3, 2}, []int{1, 1}, cnn.ConvKernalPadAll).Dilate([]int{2, 1}))
+		}, []int{4, 3, 2}, 24, 2},
+		{"conv_grouped", func() common.IHLayer {
+			return cnn.NewHLayerConv(cnn.NewConvKParam([]int{2, 2, 4}, []int{1, 1}, cnn.ConvKernalPadNo).Group(2))
+		}, []int{3, 3, 4}, 36, 2},
+		{"conv_separable", func() common.IHLayer {
+			return cnn.NewHLayerSeparableConv(cnn.NewConvKParam([]int{3, 3, 2}, []int{1, 1}, cnn.ConvKernalPadAll))
+		}, []int{3, 3, 3}, 27, 2},
+		{"conv_transpose", func() common.IHLayer {
+			return cnn.NewHLayerConvTranspose(cnn.NewConvKParam([]int{2, 3, 2}, []int{2, 1}, cnn.ConvKernalPadNo))
+		}, []int{2, 3, 3}, 18, 2},
+		{"conv_transpose_padall", func() common.IHLayer {
+			return cnn.NewHLayerConvTranspose(cnn.NewConvKParam([]int{3, 3, 2}, []int{2, 2}, cnn.ConvKernalPadAll))
+		}, []int{2, 2, 2}, 8, 2},
+		{"conv_transpose_const", func() common.IHLayer {
+			return cnn.NewHLayerConvTranspose(cnn.NewConvKParam([]int{3, 3, 2}, []int{2, 2}, cnn.ConvKernalPadAll).Fill(cnn.ConvKernalFillConst, 0.7))
+		}, []int{2, 2, 2}, 8, 2},
+		{"upsampling_nearest", func() common.IHLayer { return cnn.NewHLayerUpsamplingNearest([]int{2, 3}) }, []int{2, 2, 2}, 8, 2},
+		{"upsampling_bilinear", func() common.IHLayer { return cnn.NewHLayerUpsamplingBilinear([]int{2, 2}) }, []int{3, 2, 2}, 12, 2},
+		{"maxpooling", func() common.IHLayer {
+			return cnn.NewHLayerMaxPooling(cnn.NewConvKParam([]int{2, 2}, []int{2, 2}, cnn.ConvKernalPadNo))
+		}, []int{4, 4, 2}, 32, 2},
+		{"avgpooling", func() common.IHLayer {
+			return cnn.NewHLayerAvgPooling(cnn.NewConvKParam([]int{2, 2}, []int{1, 1}, cnn.ConvKernalPadFit))
+		}, []int{3, 3, 2}, 18, 2},
+		{"global_avgpooling", func() common.IHLayer { return cnn.NewHLayerGlobalAvgPooling() }, []int{2, 3, 2}, 12, 3},
+		{"global_maxpooling", func() common.IHLayer { return cnn.NewHLayerGlobalMaxPooling() }, []int{2, 3, 2}, 12, 3},
+		{"permute", func() common.IHLayer { return cnn.NewHLayerPermute([]int{2, 0, 1}) }, []int{2, 3, 2}, 12, 2},
+		{"layernorm", func() common.IHLayer { return nn.NewHLayerLayerNorm(0.0001) }, []int{4, 4}, 4, 3},
+		{"layernorm_single", func() common.IHLayer { return nn.NewHLayerLayerNorm(0.0001) }, []int{5, 5}, 5, 1},
+		{"layernorm_conv", func() common.IHLayer { return nn.NewHLayerLayerNorm(0.0001) }, []int{2, 2, 3}, 12, 2},
+		{"groupnorm", func() common.IHLayer { return cnn.NewHLayerGroupNorm(2, 0.0001) }, []int{2, 2, 4}, 16, 2},
+		{"instancenorm", func() common.IHLayer { return cnn.NewHLayerInstanceNorm(0.0001) }, []int{2, 3, 2}, 12, 1},
+		{"dimbatchnorm", func() common.IHLayer { return cnn.NewHLayerConvBatchNorm(0.0001, 0.9) }, []int{2, 2, 3}, 12, 4},
+		{"graph_residual", func() common.IHLayer {
+			g := nn.NewGraph()
+			x := g.Input()
+			lin := nn.NewHLayerLinear()
+			lin.InitSize([]int{4, 4})
+			h := g.Node(nil, x, lin, nn.NewHLayerTanh())
+			g.Merge(nn.NewMergeAdd(), h, x)
+			return g
+		}, nil, 4, 3},
+		{"graph_concat", func() common.IHLayer {
+			g := nn.NewGraph()
+			x := g.Input()
+			lin1, lin2 := nn.NewHLayerLinear(), nn.NewHLayerLinear()
+			lin1.InitSize([]int{2, 4})
+			lin2.InitSize([]int{3, 4})
+			g.Merge(nn.NewMergeConcat(1), g.Node(nil, x, lin1), g.Node(nil, x, lin2, nn.NewHLayerSigmoid()))
+			return g
+		}, nil, 4, 3},
+		{"graph_channel_concat", func() common.IHLayer {
+			g := nn.NewGraph()
+			x := g.Input()
+			conv := cnn.NewHLayerConv(cnn.NewConvKParam([]int{1, 1, 3}, []int{1, 1}, cnn.ConvKernalPadNo))
+			conv.InitSize([]int{2, 1, 2})
+			g.Merge(nn.NewMergeConcat(2), x, g.Node(nil, x, conv))
+			return g
+		}, nil, 4, 2},
+		{"rnn", func() common.IHLayer { return &seqLayer{rnn.NewHLayerCommonRNN(3), 2} }, []int{4, 2}, 4, 6},
+		{"rnn_sigmoid", func() common.IHLayer { return &seqLayer{rnn.NewHLayerRNN(3, nn.NewHLayerSigmoid()), 2} }, []int{3, 3}, 3, 4},
+	}
+	for i, c := range cases {
+		layer := c.layer()
+		x := RandDense(c.rows, c.batch, int64(i+2))
+		var results []Result
+		var err error
+		if initer, ok := layer.(common.IHLayerSizeIniter); ok && c.size != nil {
+			results, err = Sized(initer, c.size, x, checkEps)
+		} else {
+			results, err = Layer(layer, x, checkEps)
+		}
+		if err != nil {
+			t.Fatalf("%s check failed: %v", c.name, err)
+		}
+		for _, r := range results {
+			if r.RelErr > checkTol {
+				t.Errorf("%s gradient wrong: %v", c.name, r)
+			}
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	cases := []struct {
+		name   string
+		target common.ITarget
+		onehot bool
+		dist   bool
+	}{
+		{"ce", nn.NewTarCE(), true, false},
+		{"ce_weighted", nn.NewTarWeightedCE([]float64{0.5, 2, 1, 3}, 0.1), true, false},
+		{"ce_weighted_nosmooth", nn.NewTarWeightedCE([]float64{3, 0.5, 1, 2}, 0), true, false},
+		{"mae", nn.NewTarMAE(), false, false},
+		{"smoothmae", nn.NewTarSmoothMAE(0.5), false, false},
+		{"mse", nn.NewTarMSE(), false, false},
+		{"bce", nn.NewTarBCE(), false, false},
+		{"focal", nn.NewTarFocal(0.25, 2), true, false},
+		{"focal_soft", nn.NewTarFocal(1, 1.5), false, true},
+		{"huber", nn.NewTarHuber(0.3), false, false},
+		{"hinge", nn.NewTarHinge(1), true, false},
+		{"kl", nn.NewTarKL(), false, true},
+	}
+	for i, c := range cases {
+		pred := RandDense(4, 3, int64(i+100))
+		targ := RandDense(4, 3, int64(i+200))
+		if c.onehot {
+			targ = mat.NewDense(4, 3, nil)
+			for j := 0; j < 3; j++ {
+				targ.Set(j, j, 1)
+			}
+		}
+		if c.dist {
+			targ.Apply(func(i, j int, v float64) float64 {
+				return math.Abs(v) + 0.1
+			}, targ)
+			for j := 0; j < 3; j++ {
+				col := targ.ColView(j).(*mat.VecDense)
+				col.ScaleVec(1/mat.Sum(col), col)
+			}
+		}
+		r, err := Target(c.target, pred, targ, checkEps)
+		if err != nil {
+			t.Fatalf("%s check failed: %v", c.name, err)
+		}
+		if r.RelErr > checkTol {
+			t.Errorf("%s gradient wrong: %v", c.name, r)
+		}
+	}
+}
+
+// the pair is stacked over the labels, so it does not fit the cases above
+func TestTargetCosine(t *testing.T) {
+	labs := []float64{1, -1, -1, 1, -1}
+	for i, margin := range []float64{0, -0.3} {
+		pred := RandDense(4, 5, int64(i+300))
+		targ := mat.NewDense(5, 5, nil)
+		targ.Slice(0, 4, 0, 5).(*mat.Dense).Copy(RandDense(4, 5, int64(i+400)))
+		targ.SetRow(4, labs)
+		r, err := Target(nn.NewTarCosine(margin), pred, targ, checkEps)
+		if err != nil {
+			t.Fatalf("cosine margin %v check failed: %v", margin, err)
+		}
+		if r.RelErr > checkTol {
+			t.Errorf("cosine margin %v gradient wrong: %v", margin, r)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// rows are the target classes, columns the predicted ones
+type Confusion struct {
+	Labels []string
+	M      *mat.Dense
+}
+
+func NewConfusion(classes int) *Confusion {
+	return &Confusion{
+		M: mat.NewDense(classes, classes, nil),
+	}
+}
+
+func (c *Confusion) Classes() int {
+	r, _ := c.M.Dims()
+	return r
+}
+
+// pred and targ are (classes × batch), the argmax of each column is taken
+func (c *Confusion) Add(pred, targ mat.Matrix) {
+	pr, batch := pred.Dims()
+	tr, tc := targ.Dims()
+	if pr != c.Classes() || tr != pr || tc != batch {
+		panic(fmt.Sprintf("confusion size not match, classes:%d, pred:(%d,%d), targ:(%d,%d)", c.Classes(), pr, batch, tr, tc))
+	}
+	for j := 0; j < batch; j++ {
+		p := floats.MaxIdx(mat.Col(nil, j, pred))
+		t := floats.MaxIdx(mat.Col(nil, j, targ))
+		c.M.Set(t, p, c.M.At(t, p)+1)
+	}
+}
+
+func (c *Confusion) Adds(preds, targs []*mat.Dense) {
+	for i := range preds {
+		c.Add(preds[i], targs[i])
+	}
+}
+
+func (c *Confusion) Reset() {
+	c.M.Zero()
+}
+
+func (c *Confusion) Total() float64 {
+	return mat.Sum(c.M)
+}
+
+func (c *Confusion) Acc() float64 {
+	return safeDiv(mat.Trace(c.M), c.Total())
+}
+
+func (c *Confusion) Support(class int) float64 {
+	return floats.Sum(c.M.RawRowView(class))
+}
+
+func (c *Confusion) Precision(class int) float64 {
+	return safeDiv(c.M.At(class, class), mat.Sum(c.M.ColView(class)))
+}
+
+func (c *Confusion) Recall(class int) float64 {
+	return safeDiv(c.M.At(class, class), c.Support(class))
+}
+
+func (c *Confusion) F1(class int) float64 {
+	return f1(c.Precision(class), c.Recall(class))
+}
+
+// unweighted mean over classes
+func (c *Confusion) Macro() (precision, recall, f float64) {
+	classes := float64(c.Classes())
+	for i := 0; i < c.Classes(); i++ {
+		precision += c.Precision(i)
+		recall += c.Recall(i)
+		f += c.F1(i)
+	}
+	return precision / classes, recall / classes, f / classes
+}
+
+// pooled over all classes, every sample has one label so all three equal Acc
+func (c *Confusion) Micro() (precision, recall, f float64) {
+	tp, fp, fn := 0.0, 0.0, 0.0
+	for i := 0; i < c.Classes(); i++ {
+		hit := c.M.At(i, i)
+		tp += hit
+		fp += mat.Sum(c.M.ColView(i)) - hit
+		fn += c.Support(i) - hit
+	}
+	precision = safeDiv(tp, tp+fp)
+	recall = safeDiv(tp, tp+fn)
+	return precision, recall, f1(precision, recall)
+}
+
+func (c *Confusion) label(class int) string {
+	if class < len(c.Labels) {
+		return c.Labels[class]
+	}
+	return fmt.Sprintf("%d", class)
+}
+
+func (c *Confusion) String() string {
+	classes := c.Classes()
+	width := 6
+	for i := 0; i < classes; i++ {
+		if l := len(c.label(i)); l > width {
+			width = l
+		}
+		if l := len(fmt.Sprintf("%.0f", c.Support(i))); l > width {
+			width = l
+		}
+	}
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%*s", width, "t\\p")
+	for j := 0; j < classes; j++ {
+		fmt.Fprintf(sb, " %*s", width, c.label(j))
+	}
+	sb.WriteString("\n")
+	for i := 0; i < classes; i++ {
+		fmt.Fprintf(sb, "%*s", width, c.label(i))
+		for j := 0; j < classes; j++ {
+			fmt.Fprintf(sb, " %*.0f", width, c.M.At(i, j))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	fmt.Fprintf(sb, "%*s %9s %9s %9s %9s\n", width, "", "precision", "recall", "f1", "support")
+	for i := 0; i < classes; i++ {
+		fmt.Fprintf(sb, "%*s %9.4f %9.4f %9.4f %9.0f\n", width, c.label(i), c.Precision(i), c.Recall(i), c.F1(i), c.Support(i))
+	}
+	p, r, f := c.Macro()
+	fmt.Fprintf(sb, "%*s %9.4f %9.4f %9.4f %9.0f\n", width, "macro", p, r, f, c.Total())
+	p, r, f = c.Micro()
+	fmt.Fprintf(sb, "%*s %9.4f %9.4f %9.4f %9.0f\n", width, "micro", p, r, f, c.Total())
+	fmt.Fprintf(sb, "%*s %9.4f\n", width, "acc", c.Acc())
+	return sb.String()
+}
+
+func f1(precision, recall float64) float64 {
+	return safeDiv(2*precision*recall, precision+recall)
+}
+
+func safeDiv(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestConfusion(t *testing.T) {
+	pred := mat.NewDense(3, 6, []float64{
+		0.9, 0.1, 0.2, 0.8, 0.1, 0.3,
+		0.05, 0.8, 0.7, 0.1, 0.2, 0.3,
+		0.05, 0.1, 0.1, 0.1, 0.7, 0.4,
+	})
+	targ := mat.NewDense(3, 6, []float64{
+		1, 0, 0, 0, 0, 1,
+		0, 1, 1, 0, 0, 0,
+		0, 0, 0, 1, 1, 0,
+	})
+	c := NewConfusion(3)
+	c.Add(pred, targ)
+	tar := mat.NewDense(3, 3, []float64{
+		1, 0, 1,
+		0, 2, 0,
+		1, 0, 1,
+	})
+	if !mat.Equal(c.M, tar) {
+		t.Fatalf("confusion need:\n%v\nbut:\n%v\n", mat.Formatted(tar), mat.Formatted(c.M))
+	}
+	if c.Acc() != 4.0/6 || c.Precision(0) != 0.5 || c.Recall(1) != 1 || c.F1(2) != 0.5 {
+		t.Fatalf("class metric wrong, acc:%f, p0:%f, r1:%f, f2:%f", c.Acc(), c.Precision(0), c.Recall(1), c.F1(2))
+	}
+	p, r, f := c.Macro()
+	if p != 2.0/3 || r != 2.0/3 || f != 2.0/3 {
+		t.Fatalf("macro wrong:%f,%f,%f", p, r, f)
+	}
+	p, r, f = c.Micro()
+	if math.Abs(p-c.Acc()) > 1e-12 || math.Abs(r-c.Acc()) > 1e-12 || math.Abs(f-c.Acc()) > 1e-12 {
+		t.Fatalf("micro need acc but:%f,%f,%f", p, r, f)
+	}
+	c.Labels = []string{"cat", "dog", "bird"}
+	if s := c.String(); !strings.Contains(s, "macro") || !strings.Contains(s, "bird") {
+		t.Fatalf("render missing rows:\n%s", s)
+	}
+
+	if acc := TopK(pred, targ, 1); acc != 4.0/6 {
+		t.Fatalf("top1 need:%f but:%f", 4.0/6, acc)
+	}
+	if acc := TopK(pred, targ, 2); acc != 1 {
+		t.Fatalf("top2 need:1 but:%f", acc)
+	}
+}
+
+func TestROCAUC(t *testing.T) {
+	cases := []struct {
+		scores []float64
+		labels []float64
+		tar    float64
+	}{
+		{[]float64{0.1, 0.4, 0.35, 0.8}, []float64{0, 0, 1, 1}, 0.75},
+		{[]float64{0.1, 0.2, 0.3, 0.4}, []float64{0, 0, 1, 1}, 1},
+		{[]float64{0.5, 0.5, 0.5, 0.5}, []float64{0, 1, 0, 1}, 0.5},
+		{[]float64{0.1, 0.2}, []float64{1, 1}, math.NaN()},
+	}
+	for i, c := range cases {
+		auc := ROCAUC(c.scores, c.labels)
+		if math.IsNaN(c.tar) != math.IsNaN(auc) || math.Abs(auc-c.tar) > 1e-12 {
+			t.Fatalf("case %d auc need:%f but:%f", i, c.tar, auc)
+		}
+	}
+	pred := mat.NewDense(2, 4, []float64{
+		0.9, 0.6, 0.65, 0.2,
+		0.1, 0.4, 0.35, 0.8,
+	})
+	targ := mat.NewDense(2, 4, []float64{
+		1, 1, 0, 0,
+		0, 0, 1, 1,
+	})
+	aucs := ROCAUCs([]*mat.Dense{pred}, []*mat.Dense{targ})
+	if len(aucs) != 2 || aucs[0] != 0.75 || aucs[1] != 0.75 || MacroAUC([]*mat.Dense{pred}, []*mat.Dense{targ}) != 0.75 {
+		t.Fatalf("class auc wrong:%v", aucs)
+	}
+	// the third class has no positive, it is left out of the mean
+	pred3 := mat.NewDense(3, 4, nil)
+	pred3.Slice(0, 2, 0, 4).(*mat.Dense).Copy(pred)
+	pred3.SetRow(2, []float64{0.3, 0.2, 0.1, 0.4})
+	targ3 := mat.NewDense(3, 4, nil)
+	targ3.Slice(0, 2, 0, 4).(*mat.Dense).Copy(targ)
+	aucs = ROCAUCs([]*mat.Dense{pred3}, []*mat.Dense{targ3})
+	if !math.IsNaN(aucs[2]) || MacroAUC([]*mat.Dense{pred3}, []*mat.Dense{targ3}) != 0.75 {
+		t.Fatalf("class without positive need NaN and no part in macro auc:%v", aucs)
+	}
+}
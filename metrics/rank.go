@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// a column is a hit when its target class is within the k highest predictions
+func TopK(pred, targ mat.Matrix, k int) (acc float64) {
+	classes, batch := pred.Dims()
+	if batch == 0 {
+		return 0
+	}
+	for j := 0; j < batch; j++ {
+		col := mat.Col(nil, j, pred)
+		t := floats.MaxIdx(mat.Col(nil, j, targ))
+		higher := 0
+		for i := 0; i < classes; i++ {
+			if col[i] > col[t] {
+				higher++
+			}
+		}
+		if higher < k {
+			acc++
+		}
+	}
+	return acc / float64(batch)
+}
+
+func TopKs(preds, targs []*mat.Dense, k int) (acc float64) {
+	cnt := 0
+	for i := range preds {
+		_, batch := preds[i].Dims()
+		acc += TopK(preds[i], targs[i], k) * float64(batch)
+		cnt += batch
+	}
+	if cnt == 0 {
+		return 0
+	}
+	return acc / float64(cnt)
+}
+
+// binary area under the roc curve, labels above 0.5 are positive, ties count half,
+// NaN when only one class is present
+func ROCAUC(scores, labels []float64) float64 {
+	if len(scores) != len(labels) {
+		panic(fmt.Sprintf("roc auc size not match, scores:%d, labels:%d", len(scores), len(labels)))
+	}
+	idx := make([]int, len(scores))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return scores[idx[a]] < scores[idx[b]]
+	})
+	pos, neg, rankSum := 0.0, 0.0, 0.0
+	for i := 0; i < len(idx); {
+		end := i
+		for end < len(idx) && scores[idx[end]] == scores[idx[i]] {
+			end++
+		}
+		// the average of ranks i+1..end
+		rank := float64(i+1+end) / 2
+		for ; i < end; i++ {
+			if labels[idx[i]] > 0.5 {
+				pos++
+				rankSum += rank
+			} else {
+				neg++
+			}
+		}
+	}
+	if pos == 0 || neg == 0 {
+		return math.NaN()
+	}
+	return (rankSum - pos*(pos+1)/2) / (pos * neg)
+}
+
+// one-vs-rest auc of every class row, scores are ranked as given
+func ROCAUCs(preds, targs []*mat.Dense) (aucs []float64) {
+	if len(preds) == 0 {
+		return nil
+	}
+	classes, _ := preds[0].Dims()
+	scores := make([][]float64, classes)
+	labels := make([][]float64, classes)
+	for k := range preds {
+		for i := 0; i < classes; i++ {
+			scores[i] = append(scores[i], preds[k].RawRowView(i)...)
+			labels[i] = append(labels[i], targs[k].RawRowView(i)...)
+		}
+	}
+	aucs = make([]float64, classes)
+	for i := range aucs {
+		aucs[i] = ROCAUC(scores[i], labels[i])
+	}
+	return aucs
+}
+
+// mean of the classes which have both positive and negative samples
+func MacroAUC(preds, targs []*mat.Dense) float64 {
+	sum, cnt := 0.0, 0.0
+	for _, auc := range ROCAUCs(preds, targs) {
+		if math.IsNaN(auc) {
+			continue
+		}
+		sum += auc
+		cnt++
+	}
+	return safeDiv(sum, cnt)
+}
@@ -0,0 +1,435 @@
+package nn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	checkpointMagic   = "pneuma"
+	checkpointVersion = uint32(2)
+)
+
+var checkpointOrder = binary.LittleEndian
+
+func (m *Model) Save(w io.Writer) error {
+	return saveLayers(w, m.loss, m.layers)
+}
+
+func saveLayers(w io.Writer, loss *loss, layers []*layer) error {
+	err := writeString(w, checkpointMagic)
+	if err != nil {
+		return errors.Join(errors.New("write magic"), err)
+	}
+	err = writeUint(w, checkpointVersion)
+	if err != nil {
+		return errors.Join(errors.New("write version"), err)
+	}
+	var param *LossParam
+	if loss != nil {
+		param = loss.param
+	}
+	err = writeLossParam(w, param)
+	if err != nil {
+		return errors.Join(errors.New("write loss param"), err)
+	}
+	err = writeUint(w, uint32(len(layers)))
+	if err != nil {
+		return errors.Join(errors.New("write layer count"), err)
+	}
+	for i, l := range layers {
+		err = SaveHLayers(w, l.optimizer, l.hlayers...)
+		if err != nil {
+			return errors.Join(fmt.Errorf("save layer %d", i), err)
+		}
+	}
+	return nil
+}
+
+func (m *Model) Load(r io.Reader) error {
+	return Load(r, m)
+}
+
+func Load(r io.Reader, m *Model) error {
+	return loadLayers(r, m.loss, m.layers)
+}
+
+// the whole stream is decoded and checked before the model is touched
+func loadLayers(r io.Reader, loss *loss, layers []*layer) error {
+	magic, err := readString(r)
+	if err != nil {
+		return errors.Join(errors.New("read magic"), err)
+	}
+	if magic != checkpointMagic {
+		return fmt.Errorf("not a checkpoint, magic %q", magic)
+	}
+	version, err := readUint(r)
+	if err != nil {
+		return errors.Join(errors.New("read version"), err)
+	}
+	if version != checkpointVersion {
+		return fmt.Errorf("checkpoint version %d not supported, need %d", version, checkpointVersion)
+	}
+	param, err := readLossParam(r)
+	if err != nil {
+		return errors.Join(errors.New("read loss param"), err)
+	}
+	cnt, err := readUint(r)
+	if err != nil {
+		return errors.Join(errors.New("read layer count"), err)
+	}
+	if int(cnt) != len(layers) {
+		return fmt.Errorf("layer count not match, checkpoint:%d, model:%d", cnt, len(layers))
+	}
+	applies := make([]func(), len(layers))
+	for i, l := range layers {
+		applies[i], err = readHLayers(r, l.optimizer, l.hlayers...)
+		if err != nil {
+			return errors.Join(fmt.Errorf("load layer %d", i), err)
+		}
+	}
+	if loss != nil && param != nil {
+		loss.param = param
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+func SaveHLayers(w io.Writer, opt common.IOptimizer, hlayers ...common.IHLayer) error {
+	err := writeUint(w, uint32(len(hlayers)))
+	if err != nil {
+		return errors.Join(errors.New("write hlayer count"), err)
+	}
+	for i, hlayer := range hlayers {
+		err = writeString(w, fmt.Sprintf("%T", hlayer))
+		if err != nil {
+			return errors.Join(fmt.Errorf("write hlayer %d type", i), err)
+		}
+		datas, _ := common.OptimizeData(hlayer)
+		err = writeMatrices(w, datas)
+		if err != nil {
+			return errors.Join(fmt.Errorf("write hlayer %d datas", i), err)
+		}
+		err = writeMatrices(w, hlayerStates(hlayer))
+		if err != nil {
+			return errors.Join(fmt.Errorf("write hlayer %d states", i), err)
+		}
+	}
+	err = writeString(w, fmt.Sprintf("%T", opt))
+	if err != nil {
+		return errors.Join(errors.New("write optimizer type"), err)
+	}
+	var optStates []mat.Matrix
+	var optScalars []float64
+	if stater, ok := opt.(common.IOptimizerStater); ok {
+		optStates, optScalars = stater.State()
+	}
+	// optimizer states are grouped by the optimized datas, one group per buffer
+	groups := 0
+	if len(optStates) > 0 {
+		datas, _ := common.OptimizeData(hlayers...)
+		if len(datas) == 0 || len(optStates)%len(datas) != 0 {
+			return fmt.Errorf("optimizer has %d states for %d datas", len(optStates), len(datas))
+		}
+		groups = len(optStates) / len(datas)
+	}
+	err = writeUint(w, uint32(groups))
+	if err != nil {
+		return errors.Join(errors.New("write optimizer state groups"), err)
+	}
+	err = writeMatrices(w, optStates)
+	if err != nil {
+		return errors.Join(errors.New("write optimizer states"), err)
+	}
+	err = writeFloats(w, optScalars)
+	if err != nil {
+		return errors.Join(errors.New("write optimizer scalars"), err)
+	}
+	return nil
+}
+
+func LoadHLayers(r io.Reader, opt common.IOptimizer, hlayers ...common.IHLayer) error {
+	apply, err := readHLayers(r, opt, hlayers...)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// readHLayers decodes and checks the hlayers and the optimizer,
+// they are left untouched until apply is called
+func readHLayers(r io.Reader, opt common.IOptimizer, hlayers ...common.IHLayer) (apply func(), err error) {
+	cnt, err := readUint(r)
+	if err != nil {
+		return nil, errors.Join(errors.New("read hlayer count"), err)
+	}
+	if int(cnt) != len(hlayers) {
+		return nil, fmt.Errorf("hlayer count not match, checkpoint:%d, model:%d", cnt, len(hlayers))
+	}
+	var dsts []mat.Matrix
+	var srcs []*mat.Dense
+	for i, hlayer := range hlayers {
+		name, err := readString(r)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("read hlayer %d type", i), err)
+		}
+		if tname := fmt.Sprintf("%T", hlayer); name != tname {
+			return nil, fmt.Errorf("hlayer %d type not match, checkpoint:%s, model:%s", i, name, tname)
+		}
+		datas, _ := common.OptimizeData(hlayer)
+		ms, err := readMatricesFor(r, datas)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("read hlayer %d datas", i), err)
+		}
+		dsts, srcs = append(dsts, datas...), append(srcs, ms...)
+		states := hlayerStates(hlayer)
+		ms, err = readMatricesFor(r, states)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("read hlayer %d states", i), err)
+		}
+		dsts, srcs = append(dsts, states...), append(srcs, ms...)
+	}
+	name, err := readString(r)
+	if err != nil {
+		return nil, errors.Join(errors.New("read optimizer type"), err)
+	}
+	if tname := fmt.Sprintf("%T", opt); name != tname {
+		return nil, fmt.Errorf("optimizer type not match, checkpoint:%s, model:%s", name, tname)
+	}
+	groups, err := readUint(r)
+	if err != nil {
+		return nil, errors.Join(errors.New("read optimizer state groups"), err)
+	}
+	saved, err := readMatrices(r)
+	if err != nil {
+		return nil, errors.Join(errors.New("read optimizer states"), err)
+	}
+	scalars, err := readFloats(r)
+	if err != nil {
+		return nil, errors.Join(errors.New("read optimizer scalars"), err)
+	}
+	copyAll := func() {
+		for i, dst := range dsts {
+			copyMatrix(dst, srcs[i])
+		}
+	}
+	stater, ok := opt.(common.IOptimizerStater)
+	if !ok {
+		return copyAll, nil
+	}
+	datas, _ := common.OptimizeData(hlayers...)
+	if len(saved) != int(groups)*len(datas) {
+		return nil, fmt.Errorf("optimizer has %d states, need %d groups of %d datas", len(saved), groups, len(datas))
+	}
+	states := make([]mat.Matrix, len(saved))
+	for g := 0; g < int(groups); g++ {
+		for i, like := range datas {
+			k := g*len(datas) + i
+			err = matchDims(like, saved[k])
+			if err != nil {
+				return nil, errors.Join(fmt.Errorf("optimizer state %d", k), err)
+			}
+			states[k] = newMatrixLike(like, saved[k])
+		}
+	}
+	return func() {
+		copyAll()
+		stater.SetState(states, scalars)
+	}, nil
+}
+
+func hlayerStates(hlayer common.IHLayer) []mat.Matrix {
+	if stater, ok := hlayer.(common.IHLayerStater); ok {
+		return stater.State()
+	}
+	return nil
+}
+
+type lossParamData struct {
+	Has       bool
+	Threshold float64
+	MinLoss   float64
+	MinTimes  int64
+}
+
+func writeLossParam(w io.Writer, param *LossParam) error {
+	data := lossParamData{}
+	if param != nil {
+		data = lossParamData{
+			Has:       true,
+			Threshold: param.Threshold,
+			MinLoss:   param.MinLoss,
+			MinTimes:  int64(param.MinTimes),
+		}
+	}
+	return binary.Write(w, checkpointOrder, &data)
+}
+
+func readLossParam(r io.Reader) (*LossParam, error) {
+	data := lossParamData{}
+	err := binary.Read(r, checkpointOrder, &data)
+	if err != nil || !data.Has {
+		return nil, err
+	}
+	return &LossParam{
+		Threshold: data.Threshold,
+		MinLoss:   data.MinLoss,
+		MinTimes:  int(data.MinTimes),
+	}, nil
+}
+
+func writeUint(w io.Writer, v uint32) error {
+	return binary.Write(w, checkpointOrder, v)
+}
+
+func readUint(r io.Reader) (v uint32, err error) {
+	err = binary.Read(r, checkpointOrder, &v)
+	return
+}
+
+func writeString(w io.Writer, s string) error {
+	err := writeUint(w, uint32(len(s)))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	n, err := readUint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func writeFloats(w io.Writer, fs []float64) error {
+	err := writeUint(w, uint32(len(fs)))
+	if err != nil {
+		return err
+	}
+	return binary.Write(w, checkpointOrder, fs)
+}
+
+func readFloats(r io.Reader) ([]float64, error) {
+	n, err := readUint(r)
+	if err != nil {
+		return nil, err
+	}
+	fs := make([]float64, n)
+	err = binary.Read(r, checkpointOrder, fs)
+	return fs, err
+}
+
+func writeMatrices(w io.Writer, ms []mat.Matrix) error {
+	err := writeUint(w, uint32(len(ms)))
+	if err != nil {
+		return err
+	}
+	for i, m := range ms {
+		var dense *mat.Dense
+		switch rm := m.(type) {
+		case *mat.Dense:
+			dense = rm
+		case *mat.VecDense:
+			if rm != nil {
+				dense = mat.DenseCopyOf(rm)
+			}
+		default:
+			dense = mat.DenseCopyOf(m)
+		}
+		if dense == nil {
+			return fmt.Errorf("matrix %d not initialized", i)
+		}
+		_, err = dense.MarshalBinaryTo(w)
+		if err != nil {
+			return errors.Join(fmt.Errorf("marshal matrix %d", i), err)
+		}
+	}
+	return nil
+}
+
+func readMatrices(r io.Reader) ([]*mat.Dense, error) {
+	cnt, err := readUint(r)
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*mat.Dense, cnt)
+	for i := range ms {
+		ms[i] = &mat.Dense{}
+		_, err = ms[i].UnmarshalBinaryFrom(r)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("unmarshal matrix %d", i), err)
+		}
+	}
+	return ms, nil
+}
+
+// readMatricesFor reads the matrices and checks them against dsts without copying
+func readMatricesFor(r io.Reader, dsts []mat.Matrix) ([]*mat.Dense, error) {
+	ms, err := readMatrices(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) != len(dsts) {
+		return nil, fmt.Errorf("matrix count not match, checkpoint:%d, model:%d", len(ms), len(dsts))
+	}
+	for i, m := range ms {
+		err = matchDims(dsts[i], m)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("matrix %d", i), err)
+		}
+		switch dsts[i].(type) {
+		case *mat.Dense, *mat.VecDense:
+		default:
+			return nil, fmt.Errorf("matrix %d type %T not support", i, dsts[i])
+		}
+	}
+	return ms, nil
+}
+
+func copyMatrix(dst mat.Matrix, src *mat.Dense) {
+	switch rdst := dst.(type) {
+	case *mat.Dense:
+		rdst.Copy(src)
+	case *mat.VecDense:
+		rdst.CopyVec(src.ColView(0))
+	}
+}
+
+func matchDims(dst mat.Matrix, src *mat.Dense) error {
+	switch rdst := dst.(type) {
+	case *mat.Dense:
+		if rdst == nil {
+			return errors.New("matrix not initialized")
+		}
+	case *mat.VecDense:
+		if rdst == nil {
+			return errors.New("matrix not initialized")
+		}
+	}
+	dr, dc := dst.Dims()
+	sr, sc := src.Dims()
+	if dr != sr || dc != sc {
+		return fmt.Errorf("shape not match, checkpoint:(%d,%d), model:(%d,%d)", sr, sc, dr, dc)
+	}
+	return nil
+}
+
+func newMatrixLike(like mat.Matrix, src *mat.Dense) mat.Matrix {
+	if _, ok := like.(*mat.VecDense); ok {
+		return mat.VecDenseCopyOf(src.ColView(0))
+	}
+	return src
+}
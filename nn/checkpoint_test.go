@@ -0,0 +1,85 @@
+package nn
+
+import (
+	"bytes"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func newCheckpointModel(hidden int) *Model {
+	m := NewModel()
+	lin1 := NewHLayerLinear()
+	lin1.InitSize([]int{hidden, 3})
+	bn := NewHLayerBatchNorm(0.0001, 0.9)
+	bn.InitSize([]int{hidden, 3})
+	m.AddLayer(NewOptMomentum(0.1, 0.5), lin1, bn, NewHLayerSigmoid())
+	lin2 := NewHLayerLinear()
+	lin2.InitSize([]int{2, hidden})
+	m.AddLayer(NewOptMomentum(0.1, 0.5), lin2)
+	m.SetTarget(NewTarCE(), NewLossParam())
+	return m
+}
+
+func TestModelSaveLoad(t *testing.T) {
+	x := mat.NewDense(3, 4, []float64{
+		0.1, 0.2, 0.3, 0.4,
+		0.5, 0.1, 0.2, 0.9,
+		0.3, 0.7, 0.8, 0.2,
+	})
+	y := mat.NewDense(2, 4, []float64{
+		1, 0, 1, 0,
+		0, 1, 0, 1,
+	})
+	src := newCheckpointModel(4)
+	for i := 0; i < 3; i++ {
+		src.Train(x, y)
+	}
+	buf := &bytes.Buffer{}
+	if err := src.Save(buf); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	dst := newCheckpointModel(4)
+	if err := Load(bytes.NewReader(buf.Bytes()), dst); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	srcPred := src.Predict(x)
+	dstPred := dst.Predict(x)
+	if !mat.Equal(srcPred, dstPred) {
+		t.Fatalf("predict after load not equal need:\n%v\nbut:\n%v\n", mat.Formatted(srcPred), mat.Formatted(dstPred))
+	}
+	srcOpt, _ := src.Layer(0)
+	dstOpt, _ := dst.Layer(0)
+	srcV, _ := srcOpt.(*OptMomentum).State()
+	dstV, _ := dstOpt.(*OptMomentum).State()
+	if len(srcV) != len(dstV) {
+		t.Fatalf("momentum state count need:%d but:%d", len(srcV), len(dstV))
+	}
+	for i := range srcV {
+		if !mat.Equal(srcV[i], dstV[i]) {
+			t.Fatalf("momentum state %d not equal", i)
+		}
+	}
+	src.Train(x, y)
+	dst.Train(x, y)
+	if !mat.Equal(src.Predict(x), dst.Predict(x)) {
+		t.Fatalf("train after load diverged")
+	}
+
+	// a stream broken at its end fails after every layer is decoded
+	for _, fail := range []*Model{newCheckpointModel(5), newCheckpointModel(4)} {
+		pred := mat.DenseCopyOf(fail.Predict(x))
+		param := fail.loss.param
+		if err := Load(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), fail); err == nil {
+			t.Fatalf("load of a broken stream need error")
+		}
+		if fail.loss.param != param || !mat.Equal(fail.Predict(x), pred) {
+			t.Fatalf("failed load changed the model")
+		}
+		if opt, _ := fail.Layer(0); opt != nil {
+			if v, _ := opt.(*OptMomentum).State(); len(v) != 0 {
+				t.Fatalf("failed load set %d momentum states", len(v))
+			}
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package nn
+
+import (
+	"fmt"
+	"io"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Graph is a model over a dag, every node takes the outputs of nodes added before it,
+// so the nodes run in the order they were added and backward sums the gradients of
+// a node read by several others, a graph of one input is a hlayer as well
+type Graph struct {
+	nodes   []*graphNode
+	inputs  []int
+	output  int
+	loss    *loss
+	ws      *common.Workspace
+	ownWs   bool
+	inSizes [][]int
+}
+
+// a node runs a layer on its input, or merges its inputs, inputs have neither
+type graphNode struct {
+	layer *layer
+	merge common.IMerger
+	ins   []int
+	a     *mat.Dense
+	da    *mat.Dense
+}
+
+func NewGraph() *Graph {
+	return &Graph{output: -1}
+}
+
+func (g *Graph) add(n *graphNode) int {
+	for _, in := range n.ins {
+		if in < 0 || in >= len(g.nodes) {
+			panic(fmt.Sprintf("graph node %d not exists, %d nodes", in, len(g.nodes)))
+		}
+	}
+	g.nodes = append(g.nodes, n)
+	g.output = len(g.nodes) - 1
+	return g.output
+}
+
+// Input adds an input node, the inputs are fed in the order they were added
+func (g *Graph) Input() int {
+	id := g.add(&graphNode{})
+	g.inputs = append(g.inputs, id)
+	return id
+}
+
+// Node runs the hlayers on the output of node in, opt is nil when the graph is a hlayer
+// or the hlayers have nothing to optimize
+func (g *Graph) Node(opt common.IOptimizer, in int, hlayers ...common.IHLayer) int {
+	if g.ws != nil {
+		common.SetWorkspace(g.ws, hlayers...)
+	}
+	return g.add(&graphNode{
+		layer: &layer{optimizer: opt, hlayers: hlayers},
+		ins:   []int{in},
+	})
+}
+
+func (g *Graph) Merge(merge common.IMerger, ins ...int) int {
+	if len(ins) == 0 {
+		panic("graph merge need inputs")
+	}
+	if wser, ok := merge.(workspacer); ok && g.ws != nil {
+		wser.SetWorkspace(g.ws)
+	}
+	return g.add(&graphNode{merge: merge, ins: ins})
+}
+
+// Output marks the output node, the last added one by default
+func (g *Graph) Output(id int) {
+	if id < 0 || id >= len(g.nodes) {
+		panic(fmt.Sprintf("graph node %d not exists, %d nodes", id, len(g.nodes)))
+	}
+	g.output = id
+}
+
+func (g *Graph) SetTarget(tar common.ITarget, param *LossParam) {
+	g.loss = &loss{
+		target: tar,
+		param:  param,
+	}
+	g.loss.useWorkspace(g.ws)
+}
+
+func (g *Graph) Target() (tar common.ITarget, param *LossParam) {
+	return g.loss.target, g.loss.param
+}
+
+// UseWorkspace works as that of Model, Train and Predict reset it
+func (g *Graph) UseWorkspace(ws *common.Workspace) {
+	g.setWorkspace(ws)
+	g.ownWs = true
+}
+
+// SetWorkspace shares the workspace of the model the graph is a hlayer of, the model resets it
+func (g *Graph) SetWorkspace(ws *common.Workspace) {
+	g.setWorkspace(ws)
+	g.ownWs = false
+}
+
+func (g *Graph) setWorkspace(ws *common.Workspace) {
+	g.ws = ws
+	if g.loss != nil {
+		g.loss.useWorkspace(ws)
+	}
+	for _, n := range g.nodes {
+		if n.layer != nil {
+			common.SetWorkspace(ws, n.layer.hlayers...)
+		}
+		if wser, ok := n.merge.(workspacer); ok {
+			wser.SetWorkspace(ws)
+		}
+	}
+}
+
+func (g *Graph) run(xs []*mat.Dense, do func(l *layer, a *mat.Dense) *mat.Dense) *mat.Dense {
+	if len(xs) != len(g.inputs) {
+		panic(fmt.Sprintf("graph need %d inputs, but %d", len(g.inputs), len(xs)))
+	}
+	for i, id := range g.inputs {
+		g.nodes[id].a = xs[i]
+	}
+	for _, n := range g.nodes {
+		switch {
+		case n.layer != nil:
+			n.a = do(n.layer, g.nodes[n.ins[0]].a)
+		case n.merge != nil:
+			as := make([]*mat.Dense, len(n.ins))
+			for k, in := range n.ins {
+				as[k] = g.nodes[in].a
+			}
+			n.a = n.merge.Forward(as)
+		}
+	}
+	return g.nodes[g.output].a
+}
+
+func (g *Graph) ForwardInputs(xs []*mat.Dense) *mat.Dense {
+	return g.run(xs, (*layer).forward)
+}
+
+func (g *Graph) Forward(x *mat.Dense) *mat.Dense {
+	return g.ForwardInputs([]*mat.Dense{x})
+}
+
+// BackwardInputs gives the gradients of every input, zeros for inputs not reaching the output
+func (g *Graph) BackwardInputs(dy *mat.Dense) []*mat.Dense {
+	for _, n := range g.nodes {
+		n.da = nil
+	}
+	g.nodes[g.output].da = dy
+	for i := g.output; i >= 0; i-- {
+		n := g.nodes[i]
+		if n.da == nil {
+			continue
+		}
+		switch {
+		case n.layer != nil:
+			g.accumulate(n.ins[0], n.layer.backward(n.da))
+		case n.merge != nil:
+			for k, dx := range n.merge.Backward(n.da) {
+				g.accumulate(n.ins[k], dx)
+			}
+		}
+	}
+	das := make([]*mat.Dense, len(g.inputs))
+	for i, id := range g.inputs {
+		n := g.nodes[id]
+		das[i] = n.da
+		if das[i] == nil {
+			das[i] = g.ws.Dense(n.a.Dims())
+		}
+	}
+	return das
+}
+
+func (g *Graph) Backward(dy *mat.Dense) *mat.Dense {
+	return g.BackwardInputs(dy)[0]
+}
+
+// the gradient may be shared with a sibling, so sums go to a new matrix
+func (g *Graph) accumulate(id int, d *mat.Dense) {
+	n := g.nodes[id]
+	if n.da == nil {
+		n.da = d
+		return
+	}
+	sum := g.ws.Dense(d.Dims())
+	sum.Add(n.da, d)
+	n.da = sum
+}
+
+func (g *Graph) layers() (layers []*layer) {
+	for _, n := range g.nodes {
+		if n.layer != nil {
+			layers = append(layers, n.layer)
+		}
+	}
+	return
+}
+
+// Optimize gathers the datas of every node when the graph is a hlayer
+func (g *Graph) Optimize() (datas, deltas []mat.Matrix) {
+	for _, l := range g.layers() {
+		data, delta := common.OptimizeData(l.hlayers...)
+		datas = append(datas, data...)
+		deltas = append(deltas, delta...)
+	}
+	return
+}
+
+func (g *Graph) State() (states []mat.Matrix) {
+	for _, l := range g.layers() {
+		for _, hlayer := range l.hlayers {
+			states = append(states, hlayerStates(hlayer)...)
+		}
+	}
+	return
+}
+
+// the optimized layers of the nodes reaching the output, the others get no gradient
+func (g *Graph) trainedLayers() (layers []*layer) {
+	reach := make([]bool, len(g.nodes))
+	if g.output >= 0 {
+		reach[g.output] = true
+	}
+	for i := g.output; i >= 0; i-- {
+		if !reach[i] {
+			continue
+		}
+		for _, in := range g.nodes[i].ins {
+			reach[in] = true
+		}
+	}
+	for i, n := range g.nodes {
+		if reach[i] && n.layer != nil && n.layer.optimizer != nil {
+			layers = append(layers, n.layer)
+		}
+	}
+	return
+}
+
+// Update steps the node optimizers, nodes not reaching the output are left
+func (g *Graph) Update() {
+	layers := g.trainedLayers()
+	for _, l := range layers {
+		l.prepare()
+	}
+	for _, l := range layers {
+		l.update()
+	}
+}
+
+// EpochEnd steps the schedulers of the nodes Update steps
+func (g *Graph) EpochEnd(loss float64) {
+	for _, l := range g.trainedLayers() {
+		if epoch, ok := l.optimizer.(common.IOptimizerEpoch); ok {
+			epoch.EpochEnd(loss)
+		}
+	}
+}
+
+// TrainInputs gives the gradients of every input, nil when the loss is done
+func (g *Graph) TrainInputs(xs []*mat.Dense, y *mat.Dense) []*mat.Dense {
+	g.resetWorkspace()
+	a := g.ForwardInputs(xs)
+	g.loss.forward(a, y)
+	if g.loss.isDone() {
+		return nil
+	}
+	das := g.BackwardInputs(g.loss.backward())
+	g.Update()
+	return das
+}
+
+func (g *Graph) Train(x, y *mat.Dense) *mat.Dense {
+	das := g.TrainInputs([]*mat.Dense{x}, y)
+	if das == nil {
+		return nil
+	}
+	return das[0]
+}
+
+// PredictInputs of a graph inside a model leaves the output in the workspace as other hlayers
+func (g *Graph) PredictInputs(xs []*mat.Dense) *mat.Dense {
+	g.resetWorkspace()
+	a := g.run(xs, (*layer).predict)
+	if g.ws != nil && g.ownWs {
+		return mat.DenseCopyOf(a)
+	}
+	return a
+}
+
+// a workspace shared with a model is reset by it only, the layers before still hold buffers
+func (g *Graph) resetWorkspace() {
+	if g.ownWs {
+		g.ws.Reset()
+	}
+}
+
+func (g *Graph) Predict(x *mat.Dense) *mat.Dense {
+	return g.PredictInputs([]*mat.Dense{x})
+}
+
+func (g *Graph) Test(x, y *mat.Dense) (loss, acc float64) {
+	pred := g.Predict(x)
+	loss = g.loss.target.Loss(pred, y)
+	acc = g.loss.target.Acc(pred, y)
+	return
+}
+
+func (g *Graph) Tests(x, y []*mat.Dense) (loss, acc float64) {
+	cnt := len(x)
+	for i := 0; i < cnt; i++ {
+		oneloss, oneacc := g.Test(x[i], y[i])
+		loss += oneloss
+		acc += oneacc
+	}
+	return loss / float64(cnt), acc / float64(cnt)
+}
+
+func (g *Graph) LossPopMean() float64 {
+	return g.loss.popMean()
+}
+
+func (g *Graph) LossLatest() float64 {
+	return g.loss.latest()
+}
+
+func (g *Graph) IsDone() bool {
+	return g.loss.isDone()
+}
+
+func (g *Graph) Save(w io.Writer) error {
+	return saveLayers(w, g.loss, g.layers())
+}
+
+func (g *Graph) Load(r io.Reader) error {
+	return loadLayers(r, g.loss, g.layers())
+}
+
+// SetInputSize is the size of one sample of every input
+func (g *Graph) SetInputSize(sizes ...[]int) {
+	g.inSizes = sizes
+}
+
+// Summary rows are numbered by node, merges have a row of their own
+func (g *Graph) Summary() *Summary {
+	s := &Summary{}
+	sizes := make([][]int, len(g.nodes))
+	for i, id := range g.inputs {
+		if i < len(g.inSizes) {
+			sizes[id] = g.inSizes[i]
+		}
+	}
+	for id, n := range g.nodes {
+		switch {
+		case n.layer != nil:
+			size := sizes[n.ins[0]]
+			for _, h := range n.layer.hlayers {
+				size = s.Add(id, h, size)
+			}
+			sizes[id] = size
+		case n.merge != nil:
+			ins := make([][]int, len(n.ins))
+			for k, in := range n.ins {
+				ins[k] = sizes[in]
+			}
+			sizes[id] = n.merge.Size(ins)
+			s.Rows = append(s.Rows, LayerSummary{
+				Layer: id,
+				Type:  typeName(n.merge),
+				In:    ins[0],
+				Ins:   ins,
+				Out:   sizes[id],
+			})
+		}
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package nn
+
+import (
+	"bytes"
+	"pneuma/common"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// two inputs, the first through a residual block, then concatenated with the second
+func newGraphModel() *Graph {
+	g := NewGraph()
+	x1, x2 := g.Input(), g.Input()
+	lin1 := NewHLayerLinear()
+	lin1.InitSize([]int{3, 3})
+	h := g.Node(NewOptMomentum(0.1, 0.5), x1, lin1, NewHLayerTanh())
+	res := g.Merge(NewMergeAdd(), h, x1)
+	cat := g.Merge(NewMergeConcat(1), res, x2)
+	lin2 := NewHLayerLinear()
+	lin2.InitSize([]int{2, 5})
+	g.Node(NewOptMomentum(0.1, 0.5), cat, lin2)
+	g.SetTarget(NewTarCE(), NewLossParam())
+	return g
+}
+
+func graphData() (x1, x2, y *mat.Dense) {
+	x1 = mat.NewDense(3, 4, []float64{
+		0.1, 0.2, 0.3, 0.4,
+		0.5, 0.1, 0.2, 0.9,
+		0.3, 0.7, 0.8, 0.2,
+	})
+	x2 = mat.NewDense(2, 4, []float64{
+		0.9, 0.1, 0.8, 0.2,
+		0.2, 0.6, 0.1, 0.7,
+	})
+	y = mat.NewDense(2, 4, []float64{
+		1, 0, 1, 0,
+		0, 1, 0, 1,
+	})
+	return
+}
+
+func TestGraphTrain(t *testing.T) {
+	x1, x2, y := graphData()
+	g := newGraphModel()
+	g.UseWorkspace(common.NewWorkspace())
+	var first float64
+	for i := 0; i < 30; i++ {
+		das := g.TrainInputs([]*mat.Dense{x1, x2}, y)
+		if len(das) != 2 {
+			t.Fatalf("graph need 2 input gradients, but %d", len(das))
+		}
+		if r, c := das[1].Dims(); r != 2 || c != 4 {
+			t.Fatalf("second input gradient dims (%d,%d)", r, c)
+		}
+		if i == 0 {
+			first = g.LossLatest()
+		}
+	}
+	if g.LossLatest() >= first {
+		t.Fatalf("graph loss not decreasing, %f to %f", first, g.LossLatest())
+	}
+
+	buf := &bytes.Buffer{}
+	if err := g.Save(buf); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	dst := newGraphModel()
+	if err := dst.Load(buf); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	xs := []*mat.Dense{x1, x2}
+	if !mat.Equal(g.PredictInputs(xs), dst.PredictInputs(xs)) {
+		t.Fatalf("predict after load not equal")
+	}
+
+	g.SetInputSize([]int{3}, []int{2})
+	s := g.Summary()
+	if s.Params != 3*3+3+2*5+2 || len(s.Rows) != 5 {
+		t.Fatalf("summary wrong:\n%s", s)
+	}
+	if cat := s.Rows[3]; len(cat.Ins) != 2 || cat.Ins[1][0] != 2 || !strings.Contains(s.String(), "3,2") {
+		t.Fatalf("summary of concat need both inputs:\n%s", s)
+	}
+}
+
+func TestGraphHLayer(t *testing.T) {
+	x, _, y := graphData()
+	g := NewGraph()
+	in := g.Input()
+	lin := NewHLayerLinear()
+	lin.InitSize([]int{3, 3})
+	// tanh never leaves lin without a gradient, a relu of random init may
+	g.Merge(NewMergeAdd(), g.Node(nil, in, lin, NewHLayerTanh()), in)
+	out := NewHLayerLinear()
+	out.InitSize([]int{2, 3})
+	m := NewModel()
+	m.AddLayer(NewOptNormal(0.1), g)
+	m.AddLayer(NewOptNormal(0.1), out)
+	m.SetTarget(NewTarCE(), NewLossParam())
+	datas, _ := lin.Optimize()
+	before := mat.DenseCopyOf(datas[0])
+	m.Train(x, y)
+	if mat.Equal(before, datas[0]) {
+		t.Fatalf("graph hlayer not optimized by the model")
+	}
+
+	// a graph of one input is a model for the trainer
+	g = NewGraph()
+	lin = NewHLayerLinear()
+	lin.InitSize([]int{2, 3})
+	g.Node(NewOptNormal(0.1), g.Input(), lin)
+	g.SetTarget(NewTarCE(), NewLossParam())
+	param := NewTrainerParam()
+	param.Epoch = 3
+	info := NewTrainer(g, param).Fit([]*mat.Dense{x, x}, []*mat.Dense{y, y})
+	if info.Epoch != 3 || info.TrainLoss <= 0 {
+		t.Fatalf("trainer on graph run wrong, epoch:%d", info.Epoch)
+	}
+}
+
+func TestGraphNestedPredict(t *testing.T) {
+	x, _, _ := graphData()
+	newModel := func() *Model {
+		m := NewModel()
+		lin1 := NewHLayerLinear()
+		lin1.SetIniter(common.NewInitConst(0.1))
+		lin1.InitSize([]int{3, 3})
+		m.AddLayer(NewOptNormal(0.1), lin1, NewHLayerSigmoid())
+		g := NewGraph()
+		in := g.Input()
+		lin2 := NewHLayerLinear()
+		lin2.SetIniter(common.NewInitConst(0.2))
+		lin2.InitSize([]int{3, 3})
+		g.Merge(NewMergeAdd(), g.Node(nil, in, lin2, NewHLayerTanh()), in)
+		m.AddLayer(NewOptNormal(0.1), g)
+		lin3 := NewHLayerLinear()
+		lin3.SetIniter(common.NewInitConst(0.3))
+		lin3.InitSize([]int{2, 3})
+		m.AddLayer(NewOptNormal(0.1), lin3)
+		m.SetTarget(NewTarCE(), NewLossParam())
+		return m
+	}
+	plain, pooled := newModel(), newModel()
+	pooled.UseWorkspace(common.NewWorkspace())
+	for i := 0; i < 2; i++ {
+		need, pred := plain.Predict(x), pooled.Predict(x)
+		if !mat.EqualApprox(need, pred, 1e-12) {
+			t.Fatalf("nested graph predict %d with workspace need:\n%v\nbut:\n%v\n", i, mat.Formatted(need), mat.Formatted(pred))
+		}
+	}
+}
+
+func TestGraphDeadBranch(t *testing.T) {
+	x, _, y := graphData()
+	g := NewGraph()
+	in := g.Input()
+	newSched := func() *OptSchedule {
+		return NewOptSchedule(NewOptNormal(0.1), NewLRStep(1, 0.5), true)
+	}
+	dead, live := newSched(), newSched()
+	deadLin := NewHLayerLinear()
+	deadLin.InitSize([]int{3, 3})
+	g.Node(dead, in, deadLin)
+	lin := NewHLayerLinear()
+	lin.InitSize([]int{2, 3})
+	g.Output(g.Node(live, in, lin))
+	g.SetTarget(NewTarCE(), NewLossParam())
+	g.Train(x, y)
+	g.EpochEnd(g.LossPopMean())
+	if dead.Step() != 0 || live.Step() != 1 {
+		t.Fatalf("epoch need to step the scheduler of the live node only, dead:%d, live:%d", dead.Step(), live.Step())
+	}
+}
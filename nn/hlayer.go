@@ -1,33 +1,49 @@
 package nn
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
+	"pneuma/common"
 
 	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
 type HLayerLinear struct {
-	w  *mat.Dense
-	b  *mat.VecDense
-	dw *mat.Dense
-	db *mat.VecDense
-	x  *mat.Dense
-	Y  *mat.Dense
+	w      *mat.Dense
+	b      *mat.VecDense
+	dw     *mat.Dense
+	db     *mat.VecDense
+	x      *mat.Dense
+	Y      *mat.Dense
+	initer common.IInitializer
+	ws     *common.Workspace
+	datas  []mat.Matrix
+	deltas []mat.Matrix
 }
 
 func NewHLayerLinear() *HLayerLinear {
-	return &HLayerLinear{}
+	return &HLayerLinear{initer: common.NewInitRand()}
+}
+
+func (l *HLayerLinear) SetIniter(initer common.IInitializer) {
+	l.initer = initer
+}
+
+func (l *HLayerLinear) Replica() common.IHLayer {
+	return &HLayerLinear{w: l.w, b: l.b, initer: l.initer}
+}
+
+func (l *HLayerLinear) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
 }
 
 func (l *HLayerLinear) InitSize(size []int) []int {
 	r, c := size[0], size[1]
 	l.w = mat.NewDense(r, c, nil)
 	l.b = mat.NewVecDense(r, nil)
-	l.w.Apply(func(i, j int, v float64) float64 {
-		return rand.Float64() - 0.5
-	}, l.w)
+	l.initer.Init(l.w, c, r)
 	return size
 }
 
@@ -35,15 +51,19 @@ func (l *HLayerLinear) Dims() (r, c int) {
 	return l.w.Dims()
 }
 
+func (l *HLayerLinear) Summary(size []int) (out []int, mulAdds int) {
+	r, c := l.w.Dims()
+	return []int{r}, r * c
+}
+
 func (l *HLayerLinear) Forward(x *mat.Dense) (y *mat.Dense) {
 	_, c := x.Dims()
 	r := l.b.Len()
-	y = mat.NewDense(r, c, nil)
+	y = l.ws.Dense(r, c)
 	y.Mul(l.w, x)
 	for j := 0; j < c; j++ {
-		yCol := mat.NewVecDense(r, nil)
-		yCol.AddVec(y.ColView(j), l.b)
-		y.SetCol(j, yCol.RawVector().Data)
+		yCol := l.ws.ColView(y, j)
+		yCol.AddVec(yCol, l.b)
 	}
 	l.x = x
 	l.Y = y
@@ -53,27 +73,24 @@ func (l *HLayerLinear) Forward(x *mat.Dense) (y *mat.Dense) {
 func (l *HLayerLinear) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	wr, wc := l.w.Dims()
 	xr, xc := l.x.Dims()
-	db := mat.NewVecDense(wr, nil)
-	dw := mat.NewDense(wr, wc, nil)
+	db := l.ws.VecDense(wr)
+	dw := l.ws.Dense(wr, wc)
 	for j := 0; j < xc; j++ {
-		db.AddVec(db, dy.ColView(j))
+		db.AddVec(db, l.ws.ColView(dy, j))
 	}
-	dw.Mul(dy, l.x.T())
+	common.MulTrans(dw, dy, l.x, false, true)
 	l.dw = dw
 	l.db = db
-	dx = mat.NewDense(xr, xc, nil)
-	dx.Mul(l.w.T(), dy)
+	dx = l.ws.Dense(xr, xc)
+	common.MulTrans(dx, l.w, dy, true, false)
 	return
 }
 
+// the slices are kept by the layer and refilled by the next call
 func (l *HLayerLinear) Optimize() (datas, deltas []mat.Matrix) {
-	datas = []mat.Matrix{
-		l.w, l.b,
-	}
-	deltas = []mat.Matrix{
-		l.dw, l.db,
-	}
-	return
+	l.datas = append(l.datas[:0], l.w, l.b)
+	l.deltas = append(l.deltas[:0], l.dw, l.db)
+	return l.datas, l.deltas
 }
 
 type HLayerBatchNorm struct {
@@ -87,6 +104,9 @@ type HLayerBatchNorm struct {
 	XHat     *mat.Dense
 	MinStd   float64
 	Momentum float64
+	ws       *common.Workspace
+	datas    []mat.Matrix
+	deltas   []mat.Matrix
 }
 
 func NewHLayerBatchNorm(minstd, momentum float64) *HLayerBatchNorm {
@@ -96,18 +116,22 @@ func NewHLayerBatchNorm(minstd, momentum float64) *HLayerBatchNorm {
 	return l
 }
 
+func (l *HLayerBatchNorm) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
 func (l *HLayerBatchNorm) forward(xsube *mat.Dense, v *mat.VecDense) (y, xhat *mat.Dense) {
 	r, c := xsube.Dims()
 	for i := 0; i < r; i++ {
 		l.SInverse.SetVec(i, 1.0/math.Sqrt(v.AtVec(i)+l.MinStd))
 	}
-	y = mat.NewDense(r, c, nil)
-	xhat = mat.NewDense(r, c, nil)
+	y = l.ws.Dense(r, c)
+	xhat = l.ws.Dense(r, c)
 	for j := 0; j < c; j++ {
-		xsubeCol := xsube.ColView(j)
-		xhatCol := xhat.ColView(j).(*mat.VecDense)
+		xsubeCol := l.ws.ColView(xsube, j)
+		xhatCol := l.ws.ColView(xhat, j)
 		xhatCol.MulElemVec(xsubeCol, l.SInverse)
-		yCol := y.ColView(j).(*mat.VecDense)
+		yCol := l.ws.ColView(y, j)
 		yCol.MulElemVec(xhatCol, l.G)
 		yCol.AddVec(yCol, l.B)
 	}
@@ -127,18 +151,18 @@ func (l *HLayerBatchNorm) InitSize(size []int) []int {
 
 func (l *HLayerBatchNorm) Forward(x *mat.Dense) (y *mat.Dense) {
 	r, c := x.Dims()
-	e := mat.NewVecDense(r, nil)
-	v := mat.NewVecDense(r, nil)
-	ones := mat.NewVecDense(c, nil)
+	e := l.ws.VecDense(r)
+	v := l.ws.VecDense(r)
+	ones := l.ws.VecDense(c)
 	alpha := 1.0 / float64(c)
 	floats.AddConst(alpha, ones.RawVector().Data)
 	e.MulVec(x, ones)
-	xsube := mat.NewDense(r, c, nil)
-	xsubeSqual := mat.NewDense(r, c, nil)
+	xsube := l.ws.Dense(r, c)
+	xsubeSqual := l.ws.Dense(r, c)
 	for j := 0; j < c; j++ {
 		eCol := e
-		xCol := x.ColView(j)
-		xsubeCol := xsube.ColView(j).(*mat.VecDense)
+		xCol := l.ws.ColView(x, j)
+		xsubeCol := l.ws.ColView(xsube, j)
 		xsubeCol.SubVec(xCol, eCol)
 	}
 	xsubeSqual.MulElem(xsube, xsube)
@@ -155,11 +179,11 @@ func (l *HLayerBatchNorm) Predict(x *mat.Dense) (y *mat.Dense) {
 	r, c := x.Dims()
 	e := l.E
 	v := l.V
-	xsube := mat.NewDense(r, c, nil)
+	xsube := l.ws.Dense(r, c)
 	for j := 0; j < c; j++ {
 		eCol := e
-		xCol := x.ColView(j)
-		xsubeCol := xsube.ColView(j).(*mat.VecDense)
+		xCol := l.ws.ColView(x, j)
+		xsubeCol := l.ws.ColView(xsube, j)
 		xsubeCol.SubVec(xCol, eCol)
 	}
 	y, _ = l.forward(xsube, v)
@@ -168,26 +192,28 @@ func (l *HLayerBatchNorm) Predict(x *mat.Dense) (y *mat.Dense) {
 
 func (l *HLayerBatchNorm) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	xr, xc := dy.Dims()
-	dx = mat.NewDense(xr, xc, nil)
+	dx = l.ws.Dense(xr, xc)
 	m := float64(xc)
-	dg := mat.NewVecDense(xr, nil)
-	db := mat.NewVecDense(xr, nil)
+	dg := l.ws.VecDense(xr)
+	db := l.ws.VecDense(xr)
 	for i := 0; i < xr; i++ {
-		dyRow := dy.RowView(i)
+		dyRow := l.ws.RowView(dy, i)
 		sumDyRow := mat.Sum(dyRow)
-		xhatRow := l.XHat.RowView(i)
+		xhatRow := l.ws.RowView(l.XHat, i)
 		sumXhatDyRow := mat.Dot(dyRow, xhatRow)
 		si := l.SInverse.AtVec(i)
 		//scaler = g * si / m
 		scaler := l.G.AtVec(i) * si / m
 		//d1 = m * dy
-		d1 := mat.VecDenseCopyOf(dyRow)
+		d1 := l.ws.VecDense(xc)
+		d1.CopyVec(dyRow)
 		d1.ScaleVec(m, d1)
 		//d2 = xhat*sum(hat*dy)
-		d2 := mat.VecDenseCopyOf(xhatRow)
+		d2 := l.ws.VecDense(xc)
+		d2.CopyVec(xhatRow)
 		d2.ScaleVec(sumXhatDyRow, d2)
 		//d3 = sum(dy)
-		d3 := mat.NewVecDense(xc, nil)
+		d3 := l.ws.VecDense(xc)
 		floats.AddConst(sumDyRow, d3.RawVector().Data)
 		//dx = scaler*(d1-d2-d3)
 		dxCol := d1
@@ -205,27 +231,39 @@ func (l *HLayerBatchNorm) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	return
 }
 
+// the slices are kept by the layer and refilled by the next call
 func (l *HLayerBatchNorm) Optimize() (datas, deltas []mat.Matrix) {
-	datas = []mat.Matrix{
-		l.G, l.B,
-	}
-	deltas = []mat.Matrix{
-		l.DG, l.DB,
+	l.datas = append(l.datas[:0], l.G, l.B)
+	l.deltas = append(l.deltas[:0], l.DG, l.DB)
+	return l.datas, l.deltas
+}
+
+func (l *HLayerBatchNorm) State() (states []mat.Matrix) {
+	return []mat.Matrix{
+		l.E, l.V,
 	}
-	return
 }
 
 type HLayerSigmoid struct {
-	y *mat.Dense
+	y  *mat.Dense
+	ws *common.Workspace
 }
 
 func NewHLayerSigmoid() *HLayerSigmoid {
 	return &HLayerSigmoid{}
 }
 
+func (l *HLayerSigmoid) Replica() common.IHLayer {
+	return NewHLayerSigmoid()
+}
+
+func (l *HLayerSigmoid) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
 func (l *HLayerSigmoid) Forward(x *mat.Dense) (y *mat.Dense) {
 	r, c := x.Dims()
-	y = mat.NewDense(r, c, nil)
+	y = l.ws.Dense(r, c)
 	y.Apply(func(i, j int, v float64) float64 {
 		return 1 / (1 + math.Exp(-v))
 	}, x)
@@ -235,7 +273,7 @@ func (l *HLayerSigmoid) Forward(x *mat.Dense) (y *mat.Dense) {
 
 func (l *HLayerSigmoid) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	r, c := dy.Dims()
-	one := mat.NewDense(r, c, nil)
+	one := l.ws.Dense(r, c)
 	floats.AddConst(1, one.RawMatrix().Data)
 	oneSubY := one
 	oneSubY.Sub(one, l.y)
@@ -246,44 +284,64 @@ func (l *HLayerSigmoid) Backward(dy *mat.Dense) (dx *mat.Dense) {
 }
 
 type HLayerTanh struct {
-	y *mat.Dense
+	y  *mat.Dense
+	ws *common.Workspace
 }
 
 func NewHLayerTanh() *HLayerTanh {
 	return &HLayerTanh{}
 }
 
+func (l *HLayerTanh) Replica() common.IHLayer {
+	return NewHLayerTanh()
+}
+
+func (l *HLayerTanh) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
 func (l *HLayerTanh) Forward(x *mat.Dense) (y *mat.Dense) {
 	r, c := x.Dims()
-	y = mat.NewDense(r, c, nil)
+	y = l.ws.Dense(r, c)
 	y.Apply(func(i, j int, v float64) float64 {
 		exp := math.Exp(v)
 		expi := 1.0 / exp
 		return (exp - expi) / (exp + expi)
 	}, x)
+	l.y = y
 	return
 }
 
 func (l *HLayerTanh) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	r, c := dy.Dims()
-	dx = mat.NewDense(r, c, nil)
+	dx = l.ws.Dense(r, c)
 	dx.Apply(func(i, j int, v float64) float64 {
 		return 1 - v*v
 	}, l.y)
+	dx.MulElem(dx, dy)
 	return
 }
 
 type HLayerRelu struct {
 	phi *mat.Dense
+	ws  *common.Workspace
 }
 
 func NewHLayerRelu() *HLayerRelu {
 	return &HLayerRelu{}
 }
 
+func (l *HLayerRelu) Replica() common.IHLayer {
+	return NewHLayerRelu()
+}
+
+func (l *HLayerRelu) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
 func (l *HLayerRelu) Forward(x *mat.Dense) (y *mat.Dense) {
 	r, c := x.Dims()
-	phi := mat.NewDense(r, c, nil)
+	phi := l.ws.Dense(r, c)
 	phi.Apply(func(i, j int, v float64) float64 {
 		if v > 0 {
 			return 1
@@ -291,14 +349,393 @@ func (l *HLayerRelu) Forward(x *mat.Dense) (y *mat.Dense) {
 		return 0
 	}, x)
 	l.phi = phi
-	y = mat.NewDense(r, c, nil)
+	y = l.ws.Dense(r, c)
 	y.MulElem(phi, x)
 	return
 }
 
 func (l *HLayerRelu) Backward(dy *mat.Dense) (dx *mat.Dense) {
 	r, c := dy.Dims()
-	dx = mat.NewDense(r, c, nil)
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(l.phi, dy)
+	return
+}
+
+// y and the local derivative of an element-wise activation
+func activate(ws *common.Workspace, x *mat.Dense, fn func(v float64) (y, d float64)) (y, phi *mat.Dense) {
+	r, c := x.Dims()
+	y = ws.Dense(r, c)
+	phi = ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v, d := fn(x.At(i, j))
+			y.Set(i, j, v)
+			phi.Set(i, j, d)
+		}
+	}
+	return
+}
+
+func sigmoid(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
+
+type HLayerLeakyRelu struct {
+	Slope float64
+	phi   *mat.Dense
+	ws    *common.Workspace
+}
+
+func NewHLayerLeakyRelu(slope float64) *HLayerLeakyRelu {
+	return &HLayerLeakyRelu{Slope: slope}
+}
+
+func (l *HLayerLeakyRelu) Replica() common.IHLayer {
+	return NewHLayerLeakyRelu(l.Slope)
+}
+
+func (l *HLayerLeakyRelu) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerLeakyRelu) Forward(x *mat.Dense) (y *mat.Dense) {
+	y, l.phi = activate(l.ws, x, func(v float64) (float64, float64) {
+		if v > 0 {
+			return v, 1
+		}
+		return l.Slope * v, l.Slope
+	})
+	return
+}
+
+func (l *HLayerLeakyRelu) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(l.phi, dy)
+	return
+}
+
+// leaky relu with a learnable slope for every feature row
+type HLayerPRelu struct {
+	A      *mat.VecDense
+	DA     *mat.VecDense
+	Init   float64
+	x      *mat.Dense
+	datas  []mat.Matrix
+	deltas []mat.Matrix
+	ws     *common.Workspace
+}
+
+func NewHLayerPRelu(init float64) *HLayerPRelu {
+	return &HLayerPRelu{Init: init}
+}
+
+func (l *HLayerPRelu) Replica() common.IHLayer {
+	return &HLayerPRelu{A: l.A, Init: l.Init}
+}
+
+func (l *HLayerPRelu) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+// one slope for each row of a full size [out, in], for each channel of a
+// conv size, the channel is the last dim and the fastest of the rows
+func (l *HLayerPRelu) InitSize(size []int) []int {
+	n := size[0]
+	if len(size) > 2 {
+		n = size[len(size)-1]
+	}
+	l.A = mat.NewVecDense(n, nil)
+	floats.AddConst(l.Init, l.A.RawVector().Data)
+	l.DA = mat.NewVecDense(n, nil)
+	return size
+}
+
+func (l *HLayerPRelu) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(r, c)
+	y.Apply(func(i, j int, v float64) float64 {
+		if v > 0 {
+			return v
+		}
+		return l.A.AtVec(i%l.A.Len()) * v
+	}, x)
+	l.x = x
+	return
+}
+
+func (l *HLayerPRelu) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	n := l.A.Len()
+	da := l.ws.VecDense(n)
+	for i := 0; i < r; i++ {
+		a := l.A.AtVec(i % n)
+		sum := 0.0
+		for j := 0; j < c; j++ {
+			v := l.x.At(i, j)
+			d := dy.At(i, j)
+			if v > 0 {
+				dx.Set(i, j, d)
+				continue
+			}
+			dx.Set(i, j, a*d)
+			sum += v * d
+		}
+		da.SetVec(i%n, da.AtVec(i%n)+sum)
+	}
+	l.DA = da
+	return
+}
+
+// the slices are kept by the layer and refilled by the next call
+func (l *HLayerPRelu) Optimize() (datas, deltas []mat.Matrix) {
+	l.datas = append(l.datas[:0], l.A)
+	l.deltas = append(l.deltas[:0], l.DA)
+	return l.datas, l.deltas
+}
+
+type HLayerElu struct {
+	Alpha float64
+	phi   *mat.Dense
+	ws    *common.Workspace
+}
+
+func NewHLayerElu(alpha float64) *HLayerElu {
+	return &HLayerElu{Alpha: alpha}
+}
+
+func (l *HLayerElu) Replica() common.IHLayer {
+	return NewHLayerElu(l.Alpha)
+}
+
+func (l *HLayerElu) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerElu) Forward(x *mat.Dense) (y *mat.Dense) {
+	y, l.phi = activate(l.ws, x, func(v float64) (float64, float64) {
+		if v > 0 {
+			return v, 1
+		}
+		exp := l.Alpha * math.Exp(v)
+		return exp - l.Alpha, exp
+	})
+	return
+}
+
+func (l *HLayerElu) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(l.phi, dy)
+	return
+}
+
+// exact gelu, x * Φ(x)
+type HLayerGelu struct {
+	phi *mat.Dense
+	ws  *common.Workspace
+}
+
+func NewHLayerGelu() *HLayerGelu {
+	return &HLayerGelu{}
+}
+
+func (l *HLayerGelu) Replica() common.IHLayer {
+	return NewHLayerGelu()
+}
+
+func (l *HLayerGelu) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerGelu) Forward(x *mat.Dense) (y *mat.Dense) {
+	y, l.phi = activate(l.ws, x, func(v float64) (float64, float64) {
+		cdf := 0.5 * (1 + math.Erf(v/math.Sqrt2))
+		pdf := math.Exp(-0.5*v*v) / math.Sqrt(2*math.Pi)
+		return v * cdf, cdf + v*pdf
+	})
+	return
+}
+
+func (l *HLayerGelu) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(l.phi, dy)
+	return
+}
+
+type HLayerSoftplus struct {
+	phi *mat.Dense
+	ws  *common.Workspace
+}
+
+func NewHLayerSoftplus() *HLayerSoftplus {
+	return &HLayerSoftplus{}
+}
+
+func (l *HLayerSoftplus) Replica() common.IHLayer {
+	return NewHLayerSoftplus()
+}
+
+func (l *HLayerSoftplus) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerSoftplus) Forward(x *mat.Dense) (y *mat.Dense) {
+	y, l.phi = activate(l.ws, x, func(v float64) (float64, float64) {
+		return math.Max(v, 0) + math.Log1p(math.Exp(-math.Abs(v))), sigmoid(v)
+	})
+	return
+}
+
+func (l *HLayerSoftplus) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(l.phi, dy)
+	return
+}
+
+// x * sigmoid(beta * x), beta 1 is silu
+type HLayerSwish struct {
+	Beta float64
+	phi  *mat.Dense
+	ws   *common.Workspace
+}
+
+func NewHLayerSwish(beta float64) *HLayerSwish {
+	return &HLayerSwish{Beta: beta}
+}
+
+func (l *HLayerSwish) Replica() common.IHLayer {
+	return NewHLayerSwish(l.Beta)
+}
+
+func (l *HLayerSwish) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerSwish) Forward(x *mat.Dense) (y *mat.Dense) {
+	y, l.phi = activate(l.ws, x, func(v float64) (float64, float64) {
+		s := sigmoid(l.Beta * v)
+		return v * s, s + l.Beta*v*s*(1-s)
+	})
+	return
+}
+
+func (l *HLayerSwish) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
 	dx.MulElem(l.phi, dy)
 	return
 }
+
+// softmax over every column
+type HLayerSoftmax struct {
+	y  *mat.Dense
+	ws *common.Workspace
+}
+
+func NewHLayerSoftmax() *HLayerSoftmax {
+	return &HLayerSoftmax{}
+}
+
+func (l *HLayerSoftmax) Replica() common.IHLayer {
+	return NewHLayerSoftmax()
+}
+
+func (l *HLayerSoftmax) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerSoftmax) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(r, c)
+	for j := 0; j < c; j++ {
+		col := l.ws.ColView(x, j)
+		yCol := l.ws.ColView(y, j)
+		max := mat.Max(col)
+		sum := 0.0
+		for i := 0; i < r; i++ {
+			e := math.Exp(col.AtVec(i) - max)
+			yCol.SetVec(i, e)
+			sum += e
+		}
+		yCol.ScaleVec(1/sum, yCol)
+	}
+	l.y = y
+	return
+}
+
+func (l *HLayerSoftmax) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	//dx = y * (dy - sum(y*dy))
+	for j := 0; j < c; j++ {
+		y := l.ws.ColView(l.y, j)
+		d := l.ws.ColView(dy, j)
+		dot := mat.Dot(y, d)
+		for i := 0; i < r; i++ {
+			dx.Set(i, j, y.AtVec(i)*(d.AtVec(i)-dot))
+		}
+	}
+	return
+}
+
+type HLayerDropout struct {
+	Rate float64
+	Rand *rand.Rand
+	Mask *mat.Dense
+	ws   *common.Workspace
+}
+
+func NewHLayerDropout(rate float64, seed int64) *HLayerDropout {
+	if rate < 0 || rate >= 1 {
+		panic(fmt.Sprintf("dropout rate need in [0, 1), but %f", rate))
+	}
+	return &HLayerDropout{
+		Rate: rate,
+		Rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// the replica draws its masks from its own source
+func (l *HLayerDropout) Replica() common.IHLayer {
+	return &HLayerDropout{
+		Rate: l.Rate,
+		Rand: rand.New(rand.NewSource(l.Rand.Int63())),
+	}
+}
+
+func (l *HLayerDropout) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerDropout) Keep() float64 {
+	if l.Rand.Float64() < l.Rate {
+		return 0
+	}
+	return 1 / (1 - l.Rate)
+}
+
+func (l *HLayerDropout) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	l.Mask = l.ws.Dense(r, c)
+	l.Mask.Apply(func(i, j int, v float64) float64 {
+		return l.Keep()
+	}, l.Mask)
+	y = l.ws.Dense(r, c)
+	y.MulElem(x, l.Mask)
+	return
+}
+
+func (l *HLayerDropout) Predict(x *mat.Dense) (y *mat.Dense) {
+	return l.ws.DenseCopyOf(x)
+}
+
+func (l *HLayerDropout) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dx.MulElem(dy, l.Mask)
+	return
+}
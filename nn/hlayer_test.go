@@ -0,0 +1,76 @@
+package nn
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestHLayerDropout(t *testing.T) {
+	rate := 0.3
+	l := NewHLayerDropout(rate, 1)
+	x := mat.NewDense(50, 200, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return 1 + math.Sin(float64(i*200+j))
+	}, x)
+	y := l.Forward(x)
+
+	// kept ones scaled by 1/(1-rate), so the mean stays
+	dropped := 0.0
+	r, c := x.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			switch m := l.Mask.At(i, j); {
+			case m == 0:
+				dropped++
+			case math.Abs(m-1/(1-rate)) > 1e-12:
+				t.Fatalf("mask at %d,%d need 0 or %f but %f", i, j, 1/(1-rate), m)
+			}
+		}
+	}
+	if p := dropped / float64(r*c); math.Abs(p-rate) > 0.02 {
+		t.Fatalf("drop ratio need about %f but %f", rate, p)
+	}
+	if xm, ym := mat.Sum(x)/float64(r*c), mat.Sum(y)/float64(r*c); math.Abs(xm-ym) > 0.03 {
+		t.Fatalf("mean need kept %f but %f", xm, ym)
+	}
+
+	if !mat.Equal(l.Predict(x), x) {
+		t.Fatalf("predict need identity")
+	}
+
+	// backward reuses the mask of forward
+	dy := mat.NewDense(r, c, nil)
+	dy.Apply(func(i, j int, v float64) float64 {
+		return math.Cos(float64(i + j))
+	}, dy)
+	need := mat.NewDense(r, c, nil)
+	need.MulElem(dy, l.Mask)
+	if !mat.Equal(l.Backward(dy), need) {
+		t.Fatalf("backward need dy masked as forward")
+	}
+}
+
+func TestHLayerLayerNormConv(t *testing.T) {
+	l := NewHLayerLayerNorm(0)
+	l.InitSize([]int{2, 2, 3})
+	x := mat.NewDense(12, 2, nil)
+	x.Apply(func(i, j int, v float64) float64 {
+		return math.Sin(float64(i*2+j)) + float64(j)
+	}, x)
+	y := l.Forward(x)
+	for j := 0; j < 2; j++ {
+		col := mat.Col(nil, j, y)
+		e, v := 0.0, 0.0
+		for _, y := range col {
+			e += y
+			v += y * y
+		}
+		e /= 12
+		v = v/12 - e*e
+		if math.Abs(e) > 1e-12 || math.Abs(v-1) > 1e-9 {
+			t.Fatalf("column %d need all 12 rows normalized, mean:%f var:%f", j, e, v)
+		}
+	}
+}
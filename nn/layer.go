@@ -9,6 +9,15 @@ import (
 type layer struct {
 	hlayers   []common.IHLayer
 	optimizer common.IOptimizer
+	replicas  [][]common.IHLayer
+	ws        *common.Workspace
+	datas     []mat.Matrix
+	deltas    []mat.Matrix
+}
+
+// borrows its buffers from a workspace, as the targets and merges do
+type workspacer interface {
+	SetWorkspace(ws *common.Workspace)
 }
 
 func (l *layer) forward(a *mat.Dense) *mat.Dense {
@@ -32,6 +41,30 @@ func (l *layer) backward(da *mat.Dense) *mat.Dense {
 	return da
 }
 
+// sets ws on the hlayers and their replicas, a nil ws keeps what they have
+func (l *layer) useWorkspace(ws *common.Workspace) {
+	if ws == nil {
+		return
+	}
+	l.ws = ws
+	common.SetWorkspace(ws, l.hlayers...)
+	for _, reps := range l.replicas {
+		common.SetWorkspace(ws, reps...)
+	}
+}
+
+// the slices are kept by the layer, the optimizers must not hold them
+func (l *layer) optimizeData() (datas, deltas []mat.Matrix) {
+	l.datas, l.deltas = common.AppendOptimizeData(l.datas[:0], l.deltas[:0], l.hlayers...)
+	return l.datas, l.deltas
+}
+
+func (l *layer) prepare() {
+	if preparer, ok := l.optimizer.(common.IOptimizerPreparer); ok {
+		preparer.Prepare(l.optimizeData())
+	}
+}
+
 func (l *layer) update() {
-	l.optimizer.Update(common.OptimizeData(l.hlayers...))
+	l.optimizer.Update(l.optimizeData())
 }
@@ -0,0 +1,122 @@
+package nn
+
+import (
+	"fmt"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// sums the inputs, as the skip of a residual block
+type MergeAdd struct {
+	cnt int
+	ws  *common.Workspace
+}
+
+func NewMergeAdd() *MergeAdd {
+	return &MergeAdd{}
+}
+
+func (m *MergeAdd) SetWorkspace(ws *common.Workspace) {
+	m.ws = ws
+}
+
+func (m *MergeAdd) Forward(xs []*mat.Dense) (y *mat.Dense) {
+	r, c := xs[0].Dims()
+	y = m.ws.Dense(r, c)
+	for i, x := range xs {
+		xr, xc := x.Dims()
+		if xr != r || xc != c {
+			panic(fmt.Sprintf("merge add input %d dims (%d,%d) not equal to (%d,%d)", i, xr, xc, r, c))
+		}
+		y.Add(y, x)
+	}
+	m.cnt = len(xs)
+	return
+}
+
+// every input takes dy itself, it must not be changed
+func (m *MergeAdd) Backward(dy *mat.Dense) (dxs []*mat.Dense) {
+	dxs = make([]*mat.Dense, m.cnt)
+	for i := range dxs {
+		dxs[i] = dy
+	}
+	return
+}
+
+func (m *MergeAdd) Size(sizes [][]int) []int {
+	return sizes[0]
+}
+
+// concatenates the trailing dim, the channels, over spatial positions,
+// rows of an input are its positions × its channels, spatial 1 concatenates the features
+type MergeConcat struct {
+	Spatial int
+	chs     []int
+	ws      *common.Workspace
+}
+
+func NewMergeConcat(spatial int) *MergeConcat {
+	if spatial <= 0 {
+		panic(fmt.Sprintf("merge concat spatial need positive, but %d", spatial))
+	}
+	return &MergeConcat{Spatial: spatial}
+}
+
+func (m *MergeConcat) SetWorkspace(ws *common.Workspace) {
+	m.ws = ws
+}
+
+func (m *MergeConcat) Forward(xs []*mat.Dense) (y *mat.Dense) {
+	_, c := xs[0].Dims()
+	m.chs = make([]int, len(xs))
+	chSum := 0
+	for i, x := range xs {
+		r, xc := x.Dims()
+		if r%m.Spatial != 0 || xc != c {
+			panic(fmt.Sprintf("merge concat input %d dims (%d,%d) not fit spatial %d and batch %d", i, r, xc, m.Spatial, c))
+		}
+		m.chs[i] = r / m.Spatial
+		chSum += m.chs[i]
+	}
+	y = m.ws.Dense(m.Spatial*chSum, c)
+	for p := 0; p < m.Spatial; p++ {
+		off := p * chSum
+		for i, x := range xs {
+			ch := m.chs[i]
+			y.Slice(off, off+ch, 0, c).(*mat.Dense).Copy(x.Slice(p*ch, p*ch+ch, 0, c))
+			off += ch
+		}
+	}
+	return
+}
+
+func (m *MergeConcat) Backward(dy *mat.Dense) (dxs []*mat.Dense) {
+	r, c := dy.Dims()
+	chSum := r / m.Spatial
+	dxs = make([]*mat.Dense, len(m.chs))
+	for i, ch := range m.chs {
+		dxs[i] = m.ws.Dense(m.Spatial*ch, c)
+	}
+	for p := 0; p < m.Spatial; p++ {
+		off := p * chSum
+		for i, ch := range m.chs {
+			dxs[i].Slice(p*ch, p*ch+ch, 0, c).(*mat.Dense).Copy(dy.Slice(off, off+ch, 0, c))
+			off += ch
+		}
+	}
+	return
+}
+
+func (m *MergeConcat) Size(sizes [][]int) []int {
+	for _, size := range sizes {
+		if len(size) == 0 {
+			return nil
+		}
+	}
+	out := append([]int{}, sizes[0]...)
+	for _, size := range sizes[1:] {
+		out[len(out)-1] += size[len(size)-1]
+	}
+	return out
+}
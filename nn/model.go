@@ -5,12 +5,12 @@ import (
 	"time"
 
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat"
 )
 
 type ModelSample struct {
 	Lays      []common.IHLayer
 	Optimizer common.IOptimizer
+	Initer    common.IInitializer
 }
 
 func (m *ModelSample) Lay(l common.IHLayer) {
@@ -20,6 +20,10 @@ func (m *ModelSample) Lay(l common.IHLayer) {
 func (m *ModelSample) Opt(l common.IOptimizer) {
 	m.Optimizer = l
 }
+func (m *ModelSample) Init(i common.IInitializer) {
+	m.Initer = i
+}
+
 func (m *ModelSample) Use(cb func(*ModelSample)) {
 	cb(m)
 }
@@ -27,6 +31,7 @@ func (m *ModelSample) Use(cb func(*ModelSample)) {
 type ModelBuilder struct {
 	Lays      []func() common.IHLayer
 	Optimizer func() common.IOptimizer
+	Initer    func() common.IInitializer
 }
 
 func (m *ModelBuilder) Lay(l func() common.IHLayer) {
@@ -37,9 +42,17 @@ func (m *ModelBuilder) Opt(l func() common.IOptimizer) {
 	m.Optimizer = l
 }
 
+func (m *ModelBuilder) Init(i func() common.IInitializer) {
+	m.Initer = i
+}
+
 type Model struct {
-	layers []*layer
-	loss   *loss
+	layers   []*layer
+	loss     *loss
+	parallel int
+	cols     []int
+	ws       *common.Workspace
+	inSize   []int
 }
 
 func NewModel() *Model {
@@ -56,6 +69,7 @@ func (m *Model) SetLoss(tar common.ITarget, param *LossParam, lossVal []float64)
 		param:  param,
 		losses: lossVal,
 	}
+	m.loss.useWorkspace(m.ws)
 }
 
 func (m *Model) Target() (tar common.ITarget, param *LossParam) {
@@ -65,10 +79,13 @@ func (m *Model) Target() (tar common.ITarget, param *LossParam) {
 }
 
 func (m *Model) AddLayer(opt common.IOptimizer, layers ...common.IHLayer) {
-	m.layers = append(m.layers, &layer{
+	l := &layer{
 		optimizer: opt,
 		hlayers:   layers,
-	})
+	}
+	l.replicate(m.parallel)
+	l.useWorkspace(m.ws)
+	m.layers = append(m.layers, l)
 }
 
 func (m *Model) LayerCnt() int {
@@ -92,13 +109,39 @@ func (m *Model) SetLayer(idx int, opt common.IOptimizer, layers ...common.IHLaye
 		}
 		optc.SetIHLayers(clayers...)
 	}
-	m.layers[idx] = &layer{
+	l := &layer{
 		optimizer: opt,
 		hlayers:   layers,
 	}
+	l.replicate(m.parallel)
+	l.useWorkspace(m.ws)
+	m.layers[idx] = l
+}
+
+// UseWorkspace lets the hlayers and the target borrow their buffers from ws, every Train or Predict
+// takes them back, so the matrices of a step are only valid until the next one
+func (m *Model) UseWorkspace(ws *common.Workspace) {
+	m.ws = ws
+	if m.loss != nil {
+		m.loss.useWorkspace(ws)
+	}
+	for _, l := range m.layers {
+		l.ws = ws
+		common.SetWorkspace(ws, l.hlayers...)
+		for _, reps := range l.replicas {
+			common.SetWorkspace(ws, reps...)
+		}
+	}
+}
+
+func (m *Model) Workspace() *common.Workspace {
+	return m.ws
 }
 
 func (m *Model) Forward(x *mat.Dense) *mat.Dense {
+	if m.parallel > 1 {
+		return m.forwardParallel(x)
+	}
 	for i := 0; i < len(m.layers); i++ {
 		x = m.layers[i].forward(x)
 	}
@@ -106,6 +149,9 @@ func (m *Model) Forward(x *mat.Dense) *mat.Dense {
 }
 
 func (m *Model) Backward(dx *mat.Dense) *mat.Dense {
+	if m.parallel > 1 {
+		return m.backwardParallel(dx)
+	}
 	for i := len(m.layers) - 1; i >= 0; i-- {
 		dx = m.layers[i].backward(dx)
 	}
@@ -113,12 +159,24 @@ func (m *Model) Backward(dx *mat.Dense) *mat.Dense {
 }
 
 func (m *Model) Update() {
+	for i := 0; i < len(m.layers); i++ {
+		m.layers[i].prepare()
+	}
 	for i := 0; i < len(m.layers); i++ {
 		m.layers[i].update()
 	}
 }
 
+func (m *Model) EpochEnd(loss float64) {
+	for i := 0; i < len(m.layers); i++ {
+		if epoch, ok := m.layers[i].optimizer.(common.IOptimizerEpoch); ok {
+			epoch.EpochEnd(loss)
+		}
+	}
+}
+
 func (m *Model) Train(x, y *mat.Dense) *mat.Dense {
+	m.ws.Reset()
 	a := m.Forward(x)
 	m.loss.forward(a, y)
 	if m.loss.isDone() {
@@ -130,10 +188,14 @@ func (m *Model) Train(x, y *mat.Dense) *mat.Dense {
 }
 
 func (m *Model) Predict(x *mat.Dense) *mat.Dense {
+	m.ws.Reset()
 	a := x
 	for i := 0; i < len(m.layers); i++ {
 		a = m.layers[i].predict(a)
 	}
+	if m.ws != nil {
+		return mat.DenseCopyOf(a)
+	}
 	return a
 }
 
@@ -155,16 +217,11 @@ func (m *Model) Tests(x, y []*mat.Dense) (loss, acc float64) {
 }
 
 func (m *Model) LossPopMean() float64 {
-	mean := stat.Mean(m.loss.losses, nil)
-	m.loss.losses = nil
-	return mean
+	return m.loss.popMean()
 }
 
 func (m *Model) LossLatest() float64 {
-	if len(m.loss.losses) <= 0 {
-		return 0
-	}
-	return m.loss.losses[len(m.loss.losses)-1]
+	return m.loss.latest()
 }
 
 func (m *Model) IsDone() bool {
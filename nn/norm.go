@@ -0,0 +1,171 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// normalizes every column within groups of rows, so it not depends on the batch,
+// rows sharing a same param index share the gain and bias
+type HLayerNorm struct {
+	G        *mat.VecDense
+	B        *mat.VecDense
+	DG       *mat.VecDense
+	DB       *mat.VecDense
+	XHat     *mat.Dense
+	SInverse *mat.Dense
+	MinStd   float64
+	groups   [][]int
+	params   []int
+	datas    []mat.Matrix
+	deltas   []mat.Matrix
+	ws       *common.Workspace
+}
+
+func NewHLayerNorm(minstd float64) *HLayerNorm {
+	return &HLayerNorm{MinStd: minstd}
+}
+
+// groups[i] and params[i] are the group and param index of row i
+func (l *HLayerNorm) SetGroups(groups, params []int) {
+	if len(groups) != len(params) {
+		panic(fmt.Sprintf("norm groups and params size not match, %d and %d", len(groups), len(params)))
+	}
+	l.groups = nil
+	paramCnt := 0
+	for i, g := range groups {
+		for len(l.groups) <= g {
+			l.groups = append(l.groups, nil)
+		}
+		l.groups[g] = append(l.groups[g], i)
+		if params[i] >= paramCnt {
+			paramCnt = params[i] + 1
+		}
+	}
+	l.params = params
+	l.G = mat.NewVecDense(paramCnt, nil)
+	floats.AddConst(1, l.G.RawVector().Data)
+	l.B = mat.NewVecDense(paramCnt, nil)
+}
+
+func (l *HLayerNorm) Replica() common.IHLayer {
+	return &HLayerNorm{
+		G:      l.G,
+		B:      l.B,
+		MinStd: l.MinStd,
+		groups: l.groups,
+		params: l.params,
+	}
+}
+
+func (l *HLayerNorm) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+}
+
+func (l *HLayerNorm) Forward(x *mat.Dense) (y *mat.Dense) {
+	r, c := x.Dims()
+	y = l.ws.Dense(r, c)
+	l.XHat = l.ws.Dense(r, c)
+	l.SInverse = l.ws.Dense(len(l.groups), c)
+	for j := 0; j < c; j++ {
+		for g, rows := range l.groups {
+			n := float64(len(rows))
+			e := 0.0
+			for _, i := range rows {
+				e += x.At(i, j)
+			}
+			e /= n
+			v := 0.0
+			for _, i := range rows {
+				d := x.At(i, j) - e
+				v += d * d
+			}
+			v /= n
+			si := 1.0 / math.Sqrt(v+l.MinStd)
+			l.SInverse.Set(g, j, si)
+			for _, i := range rows {
+				xhat := (x.At(i, j) - e) * si
+				p := l.params[i]
+				l.XHat.Set(i, j, xhat)
+				y.Set(i, j, xhat*l.G.AtVec(p)+l.B.AtVec(p))
+			}
+		}
+	}
+	return
+}
+
+func (l *HLayerNorm) Backward(dy *mat.Dense) (dx *mat.Dense) {
+	r, c := dy.Dims()
+	dx = l.ws.Dense(r, c)
+	dg := l.ws.VecDense(l.G.Len())
+	db := l.ws.VecDense(l.B.Len())
+	for j := 0; j < c; j++ {
+		for g, rows := range l.groups {
+			n := float64(len(rows))
+			//dxhat = dy*g
+			sumDxhat, sumDxhatXhat := 0.0, 0.0
+			for _, i := range rows {
+				p := l.params[i]
+				xhat := l.XHat.At(i, j)
+				d := dy.At(i, j)
+				dxhat := d * l.G.AtVec(p)
+				sumDxhat += dxhat
+				sumDxhatXhat += dxhat * xhat
+				dg.SetVec(p, dg.AtVec(p)+d*xhat)
+				db.SetVec(p, db.AtVec(p)+d)
+			}
+			//dx = si/n*(n*dxhat-sum(dxhat)-xhat*sum(dxhat*xhat))
+			scaler := l.SInverse.At(g, j) / n
+			for _, i := range rows {
+				dxhat := dy.At(i, j) * l.G.AtVec(l.params[i])
+				dx.Set(i, j, scaler*(n*dxhat-sumDxhat-l.XHat.At(i, j)*sumDxhatXhat))
+			}
+		}
+	}
+	l.DG = dg
+	l.DB = db
+	return
+}
+
+// the slices are kept by the layer and refilled by the next call
+func (l *HLayerNorm) Optimize() (datas, deltas []mat.Matrix) {
+	l.datas = append(l.datas[:0], l.G, l.B)
+	l.deltas = append(l.deltas[:0], l.DG, l.DB)
+	return l.datas, l.deltas
+}
+
+// normalizes all features of each sample
+type HLayerLayerNorm struct {
+	*HLayerNorm
+}
+
+func NewHLayerLayerNorm(minstd float64) *HLayerLayerNorm {
+	return &HLayerLayerNorm{
+		HLayerNorm: NewHLayerNorm(minstd),
+	}
+}
+
+func (l *HLayerLayerNorm) Replica() common.IHLayer {
+	return &HLayerLayerNorm{
+		HLayerNorm: l.HLayerNorm.Replica().(*HLayerNorm),
+	}
+}
+
+// the features are the rows of a full size [out, in], all of a conv size
+func (l *HLayerLayerNorm) InitSize(size []int) []int {
+	r := size[0]
+	if len(size) > 2 {
+		r = common.IntsProd(size)
+	}
+	groups := make([]int, r)
+	params := make([]int, r)
+	for i := range params {
+		params[i] = i
+	}
+	l.SetGroups(groups, params)
+	return size
+}
@@ -1,6 +1,9 @@
 package nn
 
 import (
+	"fmt"
+	"math"
+
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -27,21 +30,22 @@ func rangeOptimize(datas, deltas []mat.Matrix, vec func(i int, x, dx *mat.VecDen
 	}
 }
 
+func (opt *OptNormal) LR() float64 {
+	return opt.lr
+}
+
+func (opt *OptNormal) SetLR(lr float64) {
+	opt.lr = lr
+}
+
 func (opt *OptNormal) Param() (lr float64) {
 	return opt.lr
 }
 
 func (opt *OptNormal) Update(datas, deltas []mat.Matrix) {
-	rangeOptimize(datas, deltas,
-		func(i int, x, dx *mat.VecDense) {
-			x.AddScaledVec(x, -opt.lr, dx)
-		},
-		func(i int, x, dx *mat.Dense) {
-			xr, xc := x.Dims()
-			v := mat.NewDense(xr, xc, nil)
-			v.Scale(opt.lr, dx)
-			x.Sub(x, v)
-		})
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, dx float64) float64 {
+		return x - opt.lr*dx
+	})
 }
 
 type OptMomentum struct {
@@ -57,10 +61,26 @@ func NewOptMomentum(lr, mt float64) *OptMomentum {
 	}
 }
 
+func (opt *OptMomentum) LR() float64 {
+	return opt.lr
+}
+
+func (opt *OptMomentum) SetLR(lr float64) {
+	opt.lr = lr
+}
+
 func (opt *OptMomentum) Param() (lr, mt float64) {
 	return opt.lr, opt.mt
 }
 
+func (opt *OptMomentum) State() (states []mat.Matrix, scalars []float64) {
+	return opt.v, nil
+}
+
+func (opt *OptMomentum) SetState(states []mat.Matrix, scalars []float64) {
+	opt.v = states
+}
+
 func (opt *OptMomentum) init(datas, deltas []mat.Matrix) {
 	if len(opt.v) == len(datas) {
 		return
@@ -78,20 +98,244 @@ func (opt *OptMomentum) init(datas, deltas []mat.Matrix) {
 
 func (opt *OptMomentum) Update(datas, deltas []mat.Matrix) {
 	opt.init(datas, deltas)
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, dx float64) float64 {
+		v := stateData(opt.v[i])
+		v[k] = opt.mt*v[k] - opt.lr*dx
+		return x + v[k]
+	})
+}
+
+// the raw data of a state made by newOptStates, k of rangeOptimizeElem indexes it
+func stateData(m mat.Matrix) []float64 {
+	switch rm := m.(type) {
+	case *mat.Dense:
+		raw := rm.RawMatrix()
+		if raw.Stride != raw.Cols {
+			panic(fmt.Sprintf("optimizer need continuous state, but stride=%d", raw.Stride))
+		}
+		return raw.Data[:raw.Rows*raw.Cols]
+	case *mat.VecDense:
+		raw := rm.RawVector()
+		if raw.Inc != 1 {
+			panic(fmt.Sprintf("optimizer need continuous state, but inc=%d", raw.Inc))
+		}
+		return raw.Data[:raw.N]
+	}
+	panic(fmt.Sprintf("optimizer not support matrix type %T", m))
+}
+
+func newOptStates(datas, deltas []mat.Matrix) []mat.Matrix {
+	states := make([]mat.Matrix, len(datas))
 	rangeOptimize(datas, deltas,
 		func(i int, x, dx *mat.VecDense) {
-			v := opt.v[i].(*mat.VecDense)
-			v.ScaleVec(opt.mt, v)
-			v.AddScaledVec(v, -opt.lr, dx)
-			x.AddVec(x, v)
+			states[i] = mat.NewVecDense(x.Len(), nil)
 		},
 		func(i int, x, dx *mat.Dense) {
-			v := opt.v[i].(*mat.Dense)
-			v.Scale(opt.mt, v)
-			xr, xc := x.Dims()
-			newV := mat.NewDense(xr, xc, nil)
-			newV.Scale(opt.lr, dx)
-			v.Sub(v, newV)
-			x.Add(x, v)
+			r, c := x.Dims()
+			states[i] = mat.NewDense(r, c, nil)
+		})
+	return states
+}
+
+// rangeOptimizeElem sets every element of the datas to what elem returns,
+// k counts the elements of datas[i] by rows, the order of stateData
+func rangeOptimizeElem(datas, deltas []mat.Matrix, elem func(i, k int, x, dx float64) float64) {
+	rangeOptimize(datas, deltas,
+		func(i int, x, dx *mat.VecDense) {
+			for k := 0; k < x.Len(); k++ {
+				x.SetVec(k, elem(i, k, x.AtVec(k), dx.AtVec(k)))
+			}
+		},
+		func(i int, x, dx *mat.Dense) {
+			r, c := x.Dims()
+			for p := 0; p < r; p++ {
+				for q := 0; q < c; q++ {
+					x.Set(p, q, elem(i, p*c+q, x.At(p, q), dx.At(p, q)))
+				}
+			}
 		})
 }
+
+type OptAdam struct {
+	lr    float64
+	beta1 float64
+	beta2 float64
+	eps   float64
+	t     int
+	m     []mat.Matrix
+	v     []mat.Matrix
+}
+
+func NewOptAdam(lr, beta1, beta2, eps float64) *OptAdam {
+	return &OptAdam{
+		lr:    lr,
+		beta1: beta1,
+		beta2: beta2,
+		eps:   eps,
+	}
+}
+
+func (opt *OptAdam) LR() float64 {
+	return opt.lr
+}
+
+func (opt *OptAdam) SetLR(lr float64) {
+	opt.lr = lr
+}
+
+func (opt *OptAdam) Param() (lr, beta1, beta2, eps float64) {
+	return opt.lr, opt.beta1, opt.beta2, opt.eps
+}
+
+func (opt *OptAdam) State() (states []mat.Matrix, scalars []float64) {
+	states = append(states, opt.m...)
+	states = append(states, opt.v...)
+	return states, []float64{float64(opt.t)}
+}
+
+func (opt *OptAdam) SetState(states []mat.Matrix, scalars []float64) {
+	n := len(states) / 2
+	opt.m = states[:n]
+	opt.v = states[n:]
+	opt.t = 0
+	if len(scalars) > 0 {
+		opt.t = int(scalars[0])
+	}
+}
+
+func (opt *OptAdam) init(datas, deltas []mat.Matrix) {
+	if len(opt.m) == len(datas) {
+		return
+	}
+	opt.m = newOptStates(datas, deltas)
+	opt.v = newOptStates(datas, deltas)
+	opt.t = 0
+}
+
+func (opt *OptAdam) Update(datas, deltas []mat.Matrix) {
+	opt.init(datas, deltas)
+	opt.t++
+	c1 := 1 - math.Pow(opt.beta1, float64(opt.t))
+	c2 := 1 - math.Pow(opt.beta2, float64(opt.t))
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, g float64) float64 {
+		m, v := stateData(opt.m[i]), stateData(opt.v[i])
+		m[k] = opt.beta1*m[k] + (1-opt.beta1)*g
+		v[k] = opt.beta2*v[k] + (1-opt.beta2)*g*g
+		return x - opt.lr*(m[k]/c1)/(math.Sqrt(v[k]/c2)+opt.eps)
+	})
+}
+
+type OptAdamW struct {
+	*OptAdam
+	decay float64
+}
+
+func NewOptAdamW(lr, beta1, beta2, eps, decay float64) *OptAdamW {
+	return &OptAdamW{
+		OptAdam: NewOptAdam(lr, beta1, beta2, eps),
+		decay:   decay,
+	}
+}
+
+func (opt *OptAdamW) Param() (lr, beta1, beta2, eps, decay float64) {
+	lr, beta1, beta2, eps = opt.OptAdam.Param()
+	return lr, beta1, beta2, eps, opt.decay
+}
+
+func (opt *OptAdamW) Update(datas, deltas []mat.Matrix) {
+	scale := 1 - opt.lr*opt.decay
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, dx float64) float64 {
+		return x * scale
+	})
+	opt.OptAdam.Update(datas, deltas)
+}
+
+type OptRMSProp struct {
+	lr  float64
+	rho float64
+	eps float64
+	v   []mat.Matrix
+}
+
+func NewOptRMSProp(lr, rho, eps float64) *OptRMSProp {
+	return &OptRMSProp{
+		lr:  lr,
+		rho: rho,
+		eps: eps,
+	}
+}
+
+func (opt *OptRMSProp) LR() float64 {
+	return opt.lr
+}
+
+func (opt *OptRMSProp) SetLR(lr float64) {
+	opt.lr = lr
+}
+
+func (opt *OptRMSProp) Param() (lr, rho, eps float64) {
+	return opt.lr, opt.rho, opt.eps
+}
+
+func (opt *OptRMSProp) State() (states []mat.Matrix, scalars []float64) {
+	return opt.v, nil
+}
+
+func (opt *OptRMSProp) SetState(states []mat.Matrix, scalars []float64) {
+	opt.v = states
+}
+
+func (opt *OptRMSProp) Update(datas, deltas []mat.Matrix) {
+	if len(opt.v) != len(datas) {
+		opt.v = newOptStates(datas, deltas)
+	}
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, g float64) float64 {
+		v := stateData(opt.v[i])
+		v[k] = opt.rho*v[k] + (1-opt.rho)*g*g
+		return x - opt.lr*g/(math.Sqrt(v[k])+opt.eps)
+	})
+}
+
+type OptAdagrad struct {
+	lr  float64
+	eps float64
+	v   []mat.Matrix
+}
+
+func NewOptAdagrad(lr, eps float64) *OptAdagrad {
+	return &OptAdagrad{
+		lr:  lr,
+		eps: eps,
+	}
+}
+
+func (opt *OptAdagrad) LR() float64 {
+	return opt.lr
+}
+
+func (opt *OptAdagrad) SetLR(lr float64) {
+	opt.lr = lr
+}
+
+func (opt *OptAdagrad) Param() (lr, eps float64) {
+	return opt.lr, opt.eps
+}
+
+func (opt *OptAdagrad) State() (states []mat.Matrix, scalars []float64) {
+	return opt.v, nil
+}
+
+func (opt *OptAdagrad) SetState(states []mat.Matrix, scalars []float64) {
+	opt.v = states
+}
+
+func (opt *OptAdagrad) Update(datas, deltas []mat.Matrix) {
+	if len(opt.v) != len(datas) {
+		opt.v = newOptStates(datas, deltas)
+	}
+	rangeOptimizeElem(datas, deltas, func(i, k int, x, g float64) float64 {
+		v := stateData(opt.v[i])
+		v[k] += g * g
+		return x - opt.lr*g/(math.Sqrt(v[k])+opt.eps)
+	})
+}
@@ -0,0 +1,87 @@
+package nn
+
+import (
+	"math"
+	"pneuma/common"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func optQuadLoss(opt common.IOptimizer, steps int) float64 {
+	x := mat.NewDense(2, 2, []float64{3, -2, 1, 4})
+	b := mat.NewVecDense(2, []float64{-3, 2})
+	dx := mat.NewDense(2, 2, nil)
+	db := mat.NewVecDense(2, nil)
+	for i := 0; i < steps; i++ {
+		dx.Copy(x)
+		db.CopyVec(b)
+		opt.Update([]mat.Matrix{x, b}, []mat.Matrix{dx, db})
+	}
+	return mat.Norm(x, 2) + mat.Norm(b, 2)
+}
+
+func TestOptAdaptive(t *testing.T) {
+	start := optQuadLoss(NewOptNormal(0), 1)
+	opts := map[string]common.IOptimizer{
+		"adam":    NewOptAdam(0.1, 0.9, 0.999, 1e-8),
+		"adamw":   NewOptAdamW(0.1, 0.9, 0.999, 1e-8, 0.01),
+		"rmsprop": NewOptRMSProp(0.05, 0.9, 1e-8),
+		"adagrad": NewOptAdagrad(0.5, 1e-8),
+	}
+	for name, opt := range opts {
+		loss := optQuadLoss(opt, 200)
+		if loss > start*0.05 {
+			t.Fatalf("%s not converge, start:%f, end:%f", name, start, loss)
+		}
+	}
+}
+
+func TestOptAdamFirstStep(t *testing.T) {
+	opt := NewOptAdam(0.1, 0.9, 0.999, 1e-8)
+	x := mat.NewVecDense(3, []float64{1, 1, 1})
+	dx := mat.NewVecDense(3, []float64{5, -0.01, 100})
+	opt.Update([]mat.Matrix{x}, []mat.Matrix{dx})
+	tar := []float64{0.9, 1.1, 0.9}
+	for i, v := range tar {
+		if math.Abs(x.AtVec(i)-v) > 1e-6 {
+			t.Fatalf("adam first step need:%v but:%v", tar, x.RawVector().Data)
+		}
+	}
+}
+
+func TestOptDecorators(t *testing.T) {
+	x := mat.NewVecDense(2, []float64{2, -1})
+	dx := mat.NewVecDense(2, []float64{3, 4})
+	NewOptWeightDecay(NewOptNormal(1), 0.5, 0.1).Update([]mat.Matrix{x}, []mat.Matrix{dx})
+	if !mat.EqualApprox(x, mat.NewVecDense(2, []float64{-1.7, -4.4}), 1e-12) {
+		t.Fatalf("weight decay wrong:%v", x.RawVector().Data)
+	}
+
+	dx = mat.NewVecDense(2, []float64{3, -4})
+	NewOptClipValue(NewOptNormal(0), 1).Update([]mat.Matrix{x}, []mat.Matrix{dx})
+	if !mat.Equal(dx, mat.NewVecDense(2, []float64{1, -1})) {
+		t.Fatalf("clip value wrong:%v", dx.RawVector().Data)
+	}
+
+	dx = mat.NewVecDense(2, []float64{3, -4})
+	NewOptClipNorm(NewOptNormal(0), 1).Update([]mat.Matrix{x}, []mat.Matrix{dx})
+	if !mat.EqualApprox(dx, mat.NewVecDense(2, []float64{0.6, -0.8}), 1e-12) {
+		t.Fatalf("clip norm wrong:%v", dx.RawVector().Data)
+	}
+
+	group := NewClipNormGroup(5)
+	optA := NewOptClipGlobalNorm(NewOptNormal(0), group)
+	optB := NewOptClipGlobalNorm(NewOptNormal(0), group)
+	xA := mat.NewVecDense(1, nil)
+	xB := mat.NewDense(1, 1, nil)
+	dxA := mat.NewVecDense(1, []float64{6})
+	dxB := mat.NewDense(1, 1, []float64{8})
+	optA.Prepare([]mat.Matrix{xA}, []mat.Matrix{dxA})
+	optB.Prepare([]mat.Matrix{xB}, []mat.Matrix{dxB})
+	optA.Update([]mat.Matrix{xA}, []mat.Matrix{dxA})
+	optB.Update([]mat.Matrix{xB}, []mat.Matrix{dxB})
+	if dxA.AtVec(0) != 3 || dxB.At(0, 0) != 4 || group.Norm() != 0 {
+		t.Fatalf("clip global norm wrong:%f,%f", dxA.AtVec(0), dxB.At(0, 0))
+	}
+}
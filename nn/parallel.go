@@ -0,0 +1,181 @@
+package nn
+
+import (
+	"fmt"
+	"pneuma/common"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Parallel splits the columns of every batch across cnt replicas running concurrently,
+// layers without replicas, as the batch norm, run once on the whole batch, cnt below 2 disables it
+func (m *Model) Parallel(cnt int) {
+	m.parallel = cnt
+	for _, l := range m.layers {
+		l.replicate(cnt)
+		l.useWorkspace(m.ws)
+	}
+}
+
+// replicas[h][0] is the hlayer itself, nil replicas mean the hlayer runs on the whole batch
+func (l *layer) replicate(cnt int) {
+	l.replicas = nil
+	if cnt <= 1 {
+		return
+	}
+	l.replicas = make([][]common.IHLayer, len(l.hlayers))
+	for h, hlayer := range l.hlayers {
+		replicator, ok := hlayer.(common.IHLayerReplicator)
+		if !ok {
+			continue
+		}
+		reps := []common.IHLayer{hlayer}
+		for k := 1; k < cnt; k++ {
+			rep := replicator.Replica()
+			if rep == nil {
+				reps = nil
+				break
+			}
+			reps = append(reps, rep)
+		}
+		l.replicas[h] = reps
+	}
+}
+
+func (l *layer) forwardParallel(as []*mat.Dense) []*mat.Dense {
+	for h, hlayer := range l.hlayers {
+		reps := l.replicas[h]
+		if reps == nil {
+			as = splitCols(l.ws, hlayer.Forward(joinCols(l.ws, as)), colsOf(as))
+			continue
+		}
+		parallelDo(len(as), func(k int) {
+			as[k] = reps[k].Forward(as[k])
+		})
+	}
+	return as
+}
+
+func (l *layer) backwardParallel(das []*mat.Dense) []*mat.Dense {
+	for h := len(l.hlayers) - 1; h >= 0; h-- {
+		reps := l.replicas[h]
+		if reps == nil {
+			das = splitCols(l.ws, l.hlayers[h].Backward(joinCols(l.ws, das)), colsOf(das))
+			continue
+		}
+		parallelDo(len(das), func(k int) {
+			das[k] = reps[k].Backward(das[k])
+		})
+	}
+	return das
+}
+
+// sums the deltas of the first cnt replicas into the hlayer itself
+func (l *layer) reduce(cnt int) {
+	for _, reps := range l.replicas {
+		if reps == nil {
+			continue
+		}
+		_, dst := common.OptimizeData(reps[0])
+		for k := 1; k < cnt; k++ {
+			_, src := common.OptimizeData(reps[k])
+			for i := range dst {
+				addMatrix(dst[i], src[i])
+			}
+		}
+	}
+}
+
+func (m *Model) forwardParallel(x *mat.Dense) *mat.Dense {
+	as := splitCols(m.ws, x, evenCols(x, m.parallel))
+	for _, l := range m.layers {
+		as = l.forwardParallel(as)
+	}
+	m.cols = colsOf(as)
+	return joinCols(m.ws, as)
+}
+
+func (m *Model) backwardParallel(dx *mat.Dense) *mat.Dense {
+	das := splitCols(m.ws, dx, m.cols)
+	for i := len(m.layers) - 1; i >= 0; i-- {
+		das = m.layers[i].backwardParallel(das)
+	}
+	for _, l := range m.layers {
+		l.reduce(len(das))
+	}
+	return joinCols(m.ws, das)
+}
+
+func evenCols(x *mat.Dense, cnt int) (cols []int) {
+	_, c := x.Dims()
+	if cnt > c {
+		cnt = c
+	}
+	for k := 0; k < cnt; k++ {
+		cols = append(cols, (c*(k+1))/cnt-(c*k)/cnt)
+	}
+	return
+}
+
+func colsOf(xs []*mat.Dense) (cols []int) {
+	cols = make([]int, len(xs))
+	for k, x := range xs {
+		_, cols[k] = x.Dims()
+	}
+	return
+}
+
+// the parts and the joined matrix are borrowed from ws
+func splitCols(ws *common.Workspace, x *mat.Dense, cols []int) (xs []*mat.Dense) {
+	r, _ := x.Dims()
+	xs = make([]*mat.Dense, len(cols))
+	from := 0
+	for k, c := range cols {
+		xs[k] = ws.DenseCopyOf(x.Slice(0, r, from, from+c))
+		from += c
+	}
+	return
+}
+
+func joinCols(ws *common.Workspace, xs []*mat.Dense) *mat.Dense {
+	if len(xs) == 1 {
+		return xs[0]
+	}
+	r, _ := xs[0].Dims()
+	cols := colsOf(xs)
+	sum := 0
+	for _, c := range cols {
+		sum += c
+	}
+	ret := ws.Dense(r, sum)
+	from := 0
+	for k, x := range xs {
+		ret.Slice(0, r, from, from+cols[k]).(*mat.Dense).Copy(x)
+		from += cols[k]
+	}
+	return ret
+}
+
+func parallelDo(cnt int, do func(k int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(cnt)
+	for k := 0; k < cnt; k++ {
+		go func(k int) {
+			defer wg.Done()
+			do(k)
+		}(k)
+	}
+	wg.Wait()
+}
+
+func addMatrix(dst, src mat.Matrix) {
+	switch rdst := dst.(type) {
+	case *mat.Dense:
+		rdst.Add(rdst, src)
+	case *mat.VecDense:
+		rdst.AddVec(rdst, src.(mat.Vector))
+	default:
+		panic(fmt.Sprintf("delta type %T not support", dst))
+	}
+}
@@ -0,0 +1,45 @@
+package nn
+
+import (
+	"bytes"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestModelParallel(t *testing.T) {
+	x := mat.NewDense(3, 7, []float64{
+		0.1, 0.2, 0.3, 0.4, 0.9, 0.3, 0.5,
+		0.5, 0.1, 0.2, 0.9, 0.2, 0.6, 0.1,
+		0.3, 0.7, 0.8, 0.2, 0.4, 0.1, 0.7,
+	})
+	y := mat.NewDense(2, 7, []float64{
+		1, 0, 1, 0, 1, 1, 0,
+		0, 1, 0, 1, 0, 0, 1,
+	})
+	serial := newCheckpointModel(4)
+	buf := &bytes.Buffer{}
+	if err := serial.Save(buf); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	parallel := newCheckpointModel(4)
+	if err := Load(buf, parallel); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	parallel.Parallel(3)
+	for i := 0; i < 5; i++ {
+		serialDx := serial.Train(x, y)
+		parallelDx := parallel.Train(x, y)
+		if !mat.EqualApprox(serialDx, parallelDx, 1e-12) {
+			t.Fatalf("dx at %d not equal need:\n%v\nbut:\n%v\n", i, mat.Formatted(serialDx), mat.Formatted(parallelDx))
+		}
+	}
+	if !mat.EqualApprox(serial.Predict(x), parallel.Predict(x), 1e-12) {
+		t.Fatalf("parallel training diverged from serial")
+	}
+	// a batch narrower than the replicas
+	narrow := x.Slice(0, 3, 0, 2).(*mat.Dense)
+	if !mat.EqualApprox(serial.Train(narrow, y.Slice(0, 2, 0, 2).(*mat.Dense)), parallel.Train(narrow, y.Slice(0, 2, 0, 2).(*mat.Dense)), 1e-12) {
+		t.Fatalf("narrow batch diverged")
+	}
+}
@@ -0,0 +1,227 @@
+package nn
+
+import (
+	"math"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// forwards the optional optimizer interfaces to the decorated one
+type optDecorator struct {
+	opt common.IOptimizer
+}
+
+func (d *optDecorator) inner() common.IOptimizer {
+	return d.opt
+}
+
+// HasLR tells if opt has a learning rate to set, a decorator has one only
+// when the optimizer it decorates has
+func HasLR(opt common.IOptimizer) bool {
+	switch o := opt.(type) {
+	case interface{ inner() common.IOptimizer }:
+		return HasLR(o.inner())
+	case common.IOptimizerLR:
+		return true
+	}
+	return false
+}
+
+func (d *optDecorator) LR() float64 {
+	if lr, ok := d.opt.(common.IOptimizerLR); ok {
+		return lr.LR()
+	}
+	return 0
+}
+
+func (d *optDecorator) SetLR(lr float64) {
+	if setter, ok := d.opt.(common.IOptimizerLR); ok {
+		setter.SetLR(lr)
+	}
+}
+
+func (d *optDecorator) EpochEnd(loss float64) {
+	if epoch, ok := d.opt.(common.IOptimizerEpoch); ok {
+		epoch.EpochEnd(loss)
+	}
+}
+
+func (d *optDecorator) Prepare(datas, deltas []mat.Matrix) {
+	if preparer, ok := d.opt.(common.IOptimizerPreparer); ok {
+		preparer.Prepare(datas, deltas)
+	}
+}
+
+func (d *optDecorator) State() (states []mat.Matrix, scalars []float64) {
+	if stater, ok := d.opt.(common.IOptimizerStater); ok {
+		return stater.State()
+	}
+	return nil, nil
+}
+
+func (d *optDecorator) SetState(states []mat.Matrix, scalars []float64) {
+	if stater, ok := d.opt.(common.IOptimizerStater); ok {
+		stater.SetState(states, scalars)
+	}
+}
+
+// rangeDeltaElem sets every element of the deltas to what elem returns
+func rangeDeltaElem(datas, deltas []mat.Matrix, elem func(x, dx float64) float64) {
+	rangeOptimize(datas, deltas,
+		func(i int, x, dx *mat.VecDense) {
+			for k := 0; k < x.Len(); k++ {
+				dx.SetVec(k, elem(x.AtVec(k), dx.AtVec(k)))
+			}
+		},
+		func(i int, x, dx *mat.Dense) {
+			r, c := x.Dims()
+			for p := 0; p < r; p++ {
+				for q := 0; q < c; q++ {
+					dx.Set(p, q, elem(x.At(p, q), dx.At(p, q)))
+				}
+			}
+		})
+}
+
+func scaleDelta(scale float64, delta mat.Matrix) {
+	switch d := delta.(type) {
+	case *mat.VecDense:
+		d.ScaleVec(scale, d)
+	case *mat.Dense:
+		d.Scale(scale, d)
+	}
+}
+
+type OptWeightDecay struct {
+	optDecorator
+	l1 float64
+	l2 float64
+}
+
+func NewOptWeightDecay(opt common.IOptimizer, l1, l2 float64) *OptWeightDecay {
+	return &OptWeightDecay{
+		optDecorator: optDecorator{opt: opt},
+		l1:           l1,
+		l2:           l2,
+	}
+}
+
+func (d *OptWeightDecay) Param() (l1, l2 float64) {
+	return d.l1, d.l2
+}
+
+func (d *OptWeightDecay) Update(datas, deltas []mat.Matrix) {
+	rangeDeltaElem(datas, deltas, func(x, dx float64) float64 {
+		if x > 0 {
+			dx += d.l1
+		} else if x < 0 {
+			dx -= d.l1
+		}
+		return dx + d.l2*x
+	})
+	d.opt.Update(datas, deltas)
+}
+
+type OptClipValue struct {
+	optDecorator
+	max float64
+}
+
+func NewOptClipValue(opt common.IOptimizer, max float64) *OptClipValue {
+	return &OptClipValue{
+		optDecorator: optDecorator{opt: opt},
+		max:          max,
+	}
+}
+
+func (d *OptClipValue) Update(datas, deltas []mat.Matrix) {
+	rangeDeltaElem(datas, deltas, func(x, dx float64) float64 {
+		return math.Max(-d.max, math.Min(d.max, dx))
+	})
+	d.opt.Update(datas, deltas)
+}
+
+type OptClipNorm struct {
+	optDecorator
+	maxNorm float64
+}
+
+func NewOptClipNorm(opt common.IOptimizer, maxNorm float64) *OptClipNorm {
+	return &OptClipNorm{
+		optDecorator: optDecorator{opt: opt},
+		maxNorm:      maxNorm,
+	}
+}
+
+func (d *OptClipNorm) Update(datas, deltas []mat.Matrix) {
+	for _, delta := range deltas {
+		norm := mat.Norm(delta, 2)
+		if norm > d.maxNorm {
+			scaleDelta(d.maxNorm/norm, delta)
+		}
+	}
+	d.opt.Update(datas, deltas)
+}
+
+// shared by the optimizers of all layers clipped together, Model.Update
+// prepares every member before the first one updates
+type ClipNormGroup struct {
+	maxNorm float64
+	sumSq   float64
+	pending int
+}
+
+func NewClipNormGroup(maxNorm float64) *ClipNormGroup {
+	return &ClipNormGroup{maxNorm: maxNorm}
+}
+
+func (g *ClipNormGroup) Norm() float64 {
+	return math.Sqrt(g.sumSq)
+}
+
+func (g *ClipNormGroup) add(deltas []mat.Matrix) {
+	for _, delta := range deltas {
+		norm := mat.Norm(delta, 2)
+		g.sumSq += norm * norm
+	}
+}
+
+type OptClipGlobalNorm struct {
+	optDecorator
+	group *ClipNormGroup
+}
+
+func NewOptClipGlobalNorm(opt common.IOptimizer, group *ClipNormGroup) *OptClipGlobalNorm {
+	return &OptClipGlobalNorm{
+		optDecorator: optDecorator{opt: opt},
+		group:        group,
+	}
+}
+
+func (d *OptClipGlobalNorm) Prepare(datas, deltas []mat.Matrix) {
+	d.group.add(deltas)
+	d.group.pending++
+	d.optDecorator.Prepare(datas, deltas)
+}
+
+func (d *OptClipGlobalNorm) Update(datas, deltas []mat.Matrix) {
+	g := d.group
+	if g.pending == 0 {
+		// not prepared with the others, clip by its own norm
+		g.add(deltas)
+		g.pending++
+	}
+	norm := g.Norm()
+	if norm > g.maxNorm {
+		scale := g.maxNorm / norm
+		for _, delta := range deltas {
+			scaleDelta(scale, delta)
+		}
+	}
+	g.pending--
+	if g.pending == 0 {
+		g.sumSq = 0
+	}
+	d.opt.Update(datas, deltas)
+}
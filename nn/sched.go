@@ -0,0 +1,214 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"pneuma/common"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type LRSchedule interface {
+	LR(base float64, step int) float64
+}
+
+type lrObserver interface {
+	Observe(loss float64)
+}
+
+type OptSchedule struct {
+	opt     common.IOptimizerLR
+	sched   LRSchedule
+	base    float64
+	step    int
+	byEpoch bool
+}
+
+func NewOptSchedule(opt common.IOptimizerLR, sched LRSchedule, byEpoch bool) *OptSchedule {
+	if !HasLR(opt) {
+		panic(fmt.Sprintf("optimizer %T has no learning rate to schedule", opt))
+	}
+	s := &OptSchedule{
+		opt:     opt,
+		sched:   sched,
+		base:    opt.LR(),
+		byEpoch: byEpoch,
+	}
+	s.apply()
+	return s
+}
+
+func (s *OptSchedule) apply() {
+	s.opt.SetLR(s.sched.LR(s.base, s.step))
+}
+
+func (s *OptSchedule) Step() int {
+	return s.step
+}
+
+func (s *OptSchedule) LR() float64 {
+	return s.opt.LR()
+}
+
+func (s *OptSchedule) SetLR(lr float64) {
+	s.base = lr
+	s.apply()
+}
+
+func (s *OptSchedule) Update(datas, deltas []mat.Matrix) {
+	s.opt.Update(datas, deltas)
+	if !s.byEpoch {
+		s.step++
+		s.apply()
+	}
+}
+
+func (s *OptSchedule) EpochEnd(loss float64) {
+	if observer, ok := s.sched.(lrObserver); ok {
+		observer.Observe(loss)
+	}
+	if epoch, ok := s.opt.(common.IOptimizerEpoch); ok {
+		epoch.EpochEnd(loss)
+	}
+	if s.byEpoch {
+		s.step++
+	}
+	s.apply()
+}
+
+func (s *OptSchedule) State() (states []mat.Matrix, scalars []float64) {
+	if stater, ok := s.opt.(common.IOptimizerStater); ok {
+		states, scalars = stater.State()
+	}
+	return states, append([]float64{s.base, float64(s.step)}, scalars...)
+}
+
+func (s *OptSchedule) SetState(states []mat.Matrix, scalars []float64) {
+	if len(scalars) >= 2 {
+		s.base = scalars[0]
+		s.step = int(scalars[1])
+		scalars = scalars[2:]
+	}
+	if stater, ok := s.opt.(common.IOptimizerStater); ok {
+		stater.SetState(states, scalars)
+	}
+	s.apply()
+}
+
+type LRStep struct {
+	size  int
+	gamma float64
+}
+
+func NewLRStep(size int, gamma float64) *LRStep {
+	if size <= 0 {
+		panic(fmt.Sprintf("lr step size need positive, but %d", size))
+	}
+	return &LRStep{size: size, gamma: gamma}
+}
+
+func (s *LRStep) LR(base float64, step int) float64 {
+	return base * math.Pow(s.gamma, float64(step/s.size))
+}
+
+type LRExp struct {
+	gamma float64
+}
+
+func NewLRExp(gamma float64) *LRExp {
+	return &LRExp{gamma: gamma}
+}
+
+func (s *LRExp) LR(base float64, step int) float64 {
+	return base * math.Pow(s.gamma, float64(step))
+}
+
+// cosine annealing, restarted every period, the period grows by mult after each restart
+type LRCosine struct {
+	period int
+	mult   int
+	minLR  float64
+}
+
+func NewLRCosine(period, mult int, minLR float64) *LRCosine {
+	if period <= 0 {
+		panic(fmt.Sprintf("lr cosine period need positive, but %d", period))
+	}
+	return &LRCosine{period: period, mult: mult, minLR: minLR}
+}
+
+func (s *LRCosine) LR(base float64, step int) float64 {
+	period := s.period
+	if s.mult <= 1 {
+		step %= period
+	} else {
+		for step >= period {
+			step -= period
+			period *= s.mult
+		}
+	}
+	return s.minLR + 0.5*(base-s.minLR)*(1+math.Cos(math.Pi*float64(step)/float64(period)))
+}
+
+type LRWarmup struct {
+	steps int
+	after LRSchedule
+}
+
+func NewLRWarmup(steps int, after LRSchedule) *LRWarmup {
+	return &LRWarmup{steps: steps, after: after}
+}
+
+func (s *LRWarmup) LR(base float64, step int) float64 {
+	if step < s.steps {
+		return base * float64(step+1) / float64(s.steps)
+	}
+	if s.after == nil {
+		return base
+	}
+	return s.after.LR(base, step-s.steps)
+}
+
+func (s *LRWarmup) Observe(loss float64) {
+	if observer, ok := s.after.(lrObserver); ok {
+		observer.Observe(loss)
+	}
+}
+
+type LRPlateau struct {
+	factor    float64
+	patience  int
+	threshold float64
+	minLR     float64
+	best      float64
+	bad       int
+	scale     float64
+}
+
+func NewLRPlateau(factor float64, patience int, threshold, minLR float64) *LRPlateau {
+	return &LRPlateau{
+		factor:    factor,
+		patience:  patience,
+		threshold: threshold,
+		minLR:     minLR,
+		best:      math.Inf(1),
+		scale:     1,
+	}
+}
+
+func (s *LRPlateau) Observe(loss float64) {
+	if loss < s.best-s.threshold {
+		s.best = loss
+		s.bad = 0
+		return
+	}
+	s.bad++
+	if s.bad > s.patience {
+		s.scale *= s.factor
+		s.bad = 0
+	}
+}
+
+func (s *LRPlateau) LR(base float64, step int) float64 {
+	return math.Max(base*s.scale, s.minLR)
+}
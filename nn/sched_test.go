@@ -0,0 +1,72 @@
+package nn
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestLRSchedule(t *testing.T) {
+	cases := []struct {
+		name  string
+		sched LRSchedule
+		steps []int
+		tar   []float64
+	}{
+		{"step", NewLRStep(2, 0.5), []int{0, 1, 2, 5}, []float64{1, 1, 0.5, 0.25}},
+		{"exp", NewLRExp(0.5), []int{0, 1, 3}, []float64{1, 0.5, 0.125}},
+		{"cosine", NewLRCosine(4, 1, 0), []int{0, 2, 4, 6}, []float64{1, 0.5, 1, 0.5}},
+		{"cosine_mult", NewLRCosine(2, 2, 0), []int{1, 2, 4, 6}, []float64{0.5, 1, 0.5, 1}},
+		{"warmup", NewLRWarmup(4, NewLRExp(0.5)), []int{0, 3, 5}, []float64{0.25, 1, 0.5}},
+	}
+	for _, c := range cases {
+		for i, step := range c.steps {
+			lr := c.sched.LR(1, step)
+			if math.Abs(lr-c.tar[i]) > 1e-9 {
+				t.Fatalf("%s at step %d need:%f but:%f", c.name, step, c.tar[i], lr)
+			}
+		}
+	}
+}
+
+func TestOptSchedule(t *testing.T) {
+	opt := NewOptNormal(1)
+	sched := NewOptSchedule(opt, NewLRStep(1, 0.5), false)
+	x := mat.NewVecDense(1, []float64{0})
+	dx := mat.NewVecDense(1, []float64{1})
+	for i := 0; i < 3; i++ {
+		sched.Update([]mat.Matrix{x}, []mat.Matrix{dx})
+	}
+	if x.AtVec(0) != -1.75 || opt.LR() != 0.125 {
+		t.Fatalf("schedule by update wrong, x:%f, lr:%f", x.AtVec(0), opt.LR())
+	}
+
+	plateau := NewOptSchedule(NewOptNormal(1), NewLRPlateau(0.1, 1, 0, 0.001), true)
+	for _, loss := range []float64{3, 2, 2, 2, 2, 2, 2, 2} {
+		plateau.EpochEnd(loss)
+	}
+	if math.Abs(plateau.LR()-0.001) > 1e-12 {
+		t.Fatalf("plateau need lr:%f but:%f", 0.001, plateau.LR())
+	}
+}
+
+// optPlain has no learning rate
+type optPlain struct{}
+
+func (optPlain) Update(datas, deltas []mat.Matrix) {}
+
+func TestOptScheduleNoLR(t *testing.T) {
+	if !HasLR(NewOptWeightDecay(NewOptNormal(1), 0, 0.1)) {
+		t.Fatalf("decorated normal need a learning rate")
+	}
+	if HasLR(NewOptWeightDecay(optPlain{}, 0, 0.1)) {
+		t.Fatalf("decorated plain need no learning rate")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("schedule of no learning rate need panic")
+		}
+	}()
+	NewOptSchedule(NewOptWeightDecay(optPlain{}, 0, 0.1), NewLRStep(1, 0.5), false)
+}
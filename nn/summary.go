@@ -0,0 +1,107 @@
+package nn
+
+import (
+	"fmt"
+	"pneuma/common"
+	"strings"
+	"text/tabwriter"
+)
+
+type LayerSummary struct {
+	Layer int
+	Type  string
+	In    []int
+	// every input of a merge, In is the first of them
+	Ins     [][]int
+	Out     []int
+	Params  int
+	MulAdds int
+}
+
+// Summary of a model, multiply-adds are of one sample
+type Summary struct {
+	Rows    []LayerSummary
+	Params  int
+	MulAdds int
+}
+
+// Add appends the row of hlayer h in layer idx and returns its output size,
+// hlayers not summarizing themselves keep the size
+func (s *Summary) Add(idx int, h common.IHLayer, in []int) (out []int) {
+	row := LayerSummary{
+		Layer: idx,
+		Type:  typeName(h),
+		In:    in,
+		Out:   in,
+	}
+	if summarizer, ok := h.(common.IHLayerSummarizer); ok {
+		row.Out, row.MulAdds = summarizer.Summary(in)
+	}
+	datas, _ := common.OptimizeData(h)
+	for _, data := range datas {
+		r, c := data.Dims()
+		row.Params += r * c
+	}
+	s.Rows = append(s.Rows, row)
+	s.Params += row.Params
+	s.MulAdds += row.MulAdds
+	return row.Out
+}
+
+func (s *Summary) String() string {
+	sb := &strings.Builder{}
+	w := tabwriter.NewWriter(sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "layer\ttype\tinput\toutput\tparams\tmul-adds\t")
+	for _, row := range s.Rows {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t\n", row.Layer, row.Type, row.inString(), sizeString(row.Out), row.Params, row.MulAdds)
+	}
+	fmt.Fprintf(w, "total\t\t\t\t%d\t%d\t\n", s.Params, s.MulAdds)
+	w.Flush()
+	return sb.String()
+}
+
+func (row LayerSummary) inString() string {
+	if len(row.Ins) == 0 {
+		return sizeString(row.In)
+	}
+	strs := make([]string, len(row.Ins))
+	for i, in := range row.Ins {
+		strs[i] = sizeString(in)
+	}
+	return strings.Join(strs, ",")
+}
+
+func typeName(v any) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+}
+
+func sizeString(size []int) string {
+	if size == nil {
+		return "-"
+	}
+	strs := make([]string, len(size))
+	for i, v := range size {
+		strs[i] = fmt.Sprint(v)
+	}
+	return strings.Join(strs, "x")
+}
+
+// SetInputSize is the size of one sample, Summary propagates it through the hlayers
+func (m *Model) SetInputSize(size []int) {
+	m.inSize = size
+}
+
+func (m *Model) InputSize() []int {
+	return m.inSize
+}
+
+func (m *Model) Summary() *Summary {
+	s := &Summary{}
+	size := m.inSize
+	for i, l := range m.layers {
+		for _, h := range l.hlayers {
+			size = s.Add(i, h, size)
+		}
+	}
+	return s
+}
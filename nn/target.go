@@ -1,11 +1,13 @@
 package nn
 
 import (
+	"fmt"
 	"math"
 	"pneuma/common"
 
 	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
 )
 
 type LossParam struct {
@@ -52,59 +54,182 @@ func (l *loss) backward() *mat.Dense {
 	return l.target.Backward()
 }
 
+// lends ws to the target, its loss and gradient are valid until ws resets
+func (l *loss) useWorkspace(ws *common.Workspace) {
+	if wser, ok := l.target.(workspacer); ok && ws != nil {
+		wser.SetWorkspace(ws)
+	}
+}
+
 func (l *loss) isDone() bool {
 	return l.param.IsDone(l.losses)
 }
 
-// cross entropy
+func (l *loss) popMean() float64 {
+	mean := stat.Mean(l.losses, nil)
+	l.losses = nil
+	return mean
+}
+
+func (l *loss) latest() float64 {
+	if len(l.losses) <= 0 {
+		return 0
+	}
+	return l.losses[len(l.losses)-1]
+}
+
+// cross entropy, Weights scale the samples by their target class,
+// Smooth mixes the target with a uniform distribution
 type TargetCE struct {
-	softmax *mat.Dense
-	target  *mat.Dense
+	Weights   []float64
+	Smooth    float64
+	softmax   *mat.Dense
+	target    *mat.Dense
+	sampleW   *mat.VecDense
+	weightSum float64
+	ws        *common.Workspace
 }
 
 func NewTarCE() *TargetCE {
 	return &TargetCE{}
 }
 
+func NewTarWeightedCE(weights []float64, smooth float64) *TargetCE {
+	if smooth < 0 || smooth >= 1 {
+		panic(fmt.Sprintf("label smoothing need in [0, 1), but %f", smooth))
+	}
+	return &TargetCE{Weights: weights, Smooth: smooth}
+}
+
+// inverse class frequency of the targets, scaled to a mean of 1
+func InvFreqWeights(targs []*mat.Dense) (weights []float64) {
+	if len(targs) == 0 {
+		return nil
+	}
+	r, _ := targs[0].Dims()
+	cnts := make([]float64, r)
+	for _, targ := range targs {
+		_, c := targ.Dims()
+		for j := 0; j < c; j++ {
+			cnts[floats.MaxIdx(mat.Col(nil, j, targ))]++
+		}
+	}
+	weights = make([]float64, r)
+	present := 0.0
+	for i, cnt := range cnts {
+		if cnt > 0 {
+			weights[i] = 1 / cnt
+			present++
+		}
+	}
+	floats.Scale(present/floats.Sum(weights), weights)
+	return
+}
+
+func (t *TargetCE) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetCE) sampleWeight(targ *mat.Dense, j int) float64 {
+	if t.Weights == nil {
+		return 1
+	}
+	r, _ := targ.Dims()
+	if len(t.Weights) != r {
+		panic(fmt.Sprintf("class weights size %d not match classes %d", len(t.Weights), r))
+	}
+	w := 0.0
+	for i := 0; i < r; i++ {
+		w += t.Weights[i] * targ.At(i, j)
+	}
+	return w
+}
+
 func (t *TargetCE) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
 	r, c := pred.Dims()
-	softmax := mat.NewDense(r, c, nil)
-	loss = mat.NewDense(r, c, nil)
+	softmax := t.ws.Dense(r, c)
+	target := t.ws.DenseCopyOf(targ)
+	t.sampleW = t.ws.VecDense(c)
+	t.weightSum = 0
+	loss = t.ws.Dense(r, c)
 	for j := 0; j < c; j++ {
-		col := pred.ColView(j)
+		col := t.ws.ColView(pred, j)
 		max := mat.Max(col)
 		sumExp := 0.0
-		softmaxCol := mat.NewVecDense(r, nil)
+		softmaxCol := t.ws.ColView(softmax, j)
 		for i := 0; i < r; i++ {
 			exp := math.Exp(col.AtVec(i) - max)
 			sumExp += exp
 			softmaxCol.SetVec(i, exp)
 		}
 		softmaxCol.ScaleVec(1.0/sumExp, softmaxCol)
-		softmax.SetCol(j, softmaxCol.RawVector().Data)
+		w := t.sampleWeight(targ, j)
+		t.sampleW.SetVec(j, w)
+		t.weightSum += w
+		targCol := t.ws.ColView(target, j)
+		if t.Smooth > 0 {
+			sumTarg := mat.Sum(targCol)
+			for i := 0; i < r; i++ {
+				targCol.SetVec(i, (1-t.Smooth)*targCol.AtVec(i)+t.Smooth*sumTarg/float64(r))
+			}
+		}
 		logSumExp := math.Log(sumExp)
 		for i := 0; i < r; i++ {
-			loss.Set(i, j, -1*targ.At(i, j)*(pred.At(i, j)-max-logSumExp))
+			loss.Set(i, j, -1*w*targCol.AtVec(i)*(pred.At(i, j)-max-logSumExp))
+		}
+	}
+	// the batch shares the loss by weight, so the mean over the batch is the one over the weights
+	if scale := t.weightScale(c); scale != 1 {
+		for j := 0; j < c; j++ {
+			col := t.ws.ColView(loss, j)
+			col.ScaleVec(scale, col)
 		}
 	}
 	t.softmax = softmax
-	t.target = targ
+	t.target = target
 	return
 }
 
+// mean over the weighted batch
 func (t *TargetCE) Loss(pred, targ *mat.Dense) (y float64) {
 	_, batch := pred.Dims()
 	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
 }
 
+// batch over the summed weights, 1 for the plain ce and 0 for a batch of no weight
+func (t *TargetCE) weightScale(batch int) float64 {
+	if t.weightSum == 0 {
+		return 0
+	}
+	return float64(batch) / t.weightSum
+}
+
 func (t *TargetCE) Backward() (dy *mat.Dense) {
 	r, c := t.target.Dims()
-	dy = mat.NewDense(r, c, nil)
-	dy.Sub(t.softmax, t.target)
+	dy = t.ws.Dense(r, c)
+	// plain ce keeps softmax-targ, all zero columns such as the ignored
+	// anchors of the rpn still push their softmax down
+	if t.Weights == nil && t.Smooth == 0 {
+		dy.Sub(t.softmax, t.target)
+		return
+	}
+	//dy = w*(softmax*sum(targ)-targ)*batch/sum(w)
+	scale := t.weightScale(c)
+	for j := 0; j < c; j++ {
+		targCol := t.ws.ColView(t.target, j)
+		dyCol := t.ws.ColView(dy, j)
+		dyCol.ScaleVec(mat.Sum(targCol), t.ws.ColView(t.softmax, j))
+		dyCol.SubVec(dyCol, targCol)
+		dyCol.ScaleVec(t.sampleW.AtVec(j)*scale, dyCol)
+	}
 	return
 }
 
 func (t *TargetCE) Acc(pred, targ *mat.Dense) (acc float64) {
+	return AccArgmax(pred, targ)
+}
+
+func AccArgmax(pred, targ *mat.Dense) (acc float64) {
 	cnt := 0.0
 	_, batch := targ.Dims()
 	for j := 0; j < batch; j++ {
@@ -138,19 +263,32 @@ func LossToAccLinear(loss mat.Matrix) (acc float64) {
 // l1
 type TargetMAE struct {
 	dy *mat.Dense
+	ws *common.Workspace
 }
 
 func NewTarMAE() *TargetMAE {
 	return &TargetMAE{}
 }
 
+func (t *TargetMAE) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
 func (t *TargetMAE) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
 	r, c := pred.Dims()
-	loss = mat.NewDense(r, c, nil)
-	loss.Sub(pred, targ)
-	loss.Apply(func(i, j int, v float64) float64 {
-		return math.Abs(v)
-	}, loss)
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v := pred.At(i, j) - targ.At(i, j)
+			if v > 0 {
+				t.dy.Set(i, j, 1)
+			} else {
+				t.dy.Set(i, j, -1)
+			}
+			loss.Set(i, j, math.Abs(v))
+		}
+	}
 	return loss
 }
 
@@ -158,13 +296,6 @@ func (t *TargetMAE) Loss(pred, targ *mat.Dense) (y float64) {
 	r, c := pred.Dims()
 	cnt := float64(r * c)
 	loss := t.LossEach(pred, targ)
-	t.dy = mat.NewDense(r, c, nil)
-	t.dy.Apply(func(i, j int, v float64) float64 {
-		if v > 0 {
-			return 1
-		}
-		return -1
-	}, loss)
 	y = mat.Sum(loss) / cnt
 	return
 }
@@ -181,30 +312,38 @@ func (t *TargetMAE) Acc(pred, targ *mat.Dense) (acc float64) {
 type TargetSmoothMAE struct {
 	beta float64
 	dy   *mat.Dense
+	ws   *common.Workspace
 }
 
 func NewTarSmoothMAE(beta float64) *TargetSmoothMAE {
 	return &TargetSmoothMAE{beta: beta}
 }
 
+func (t *TargetSmoothMAE) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
 func (t *TargetSmoothMAE) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
 	r, c := pred.Dims()
-	t.dy = mat.NewDense(r, c, nil)
-	loss = mat.NewDense(r, c, nil)
-	loss.Sub(pred, targ)
-	loss.Apply(func(i, j int, v float64) float64 {
-		a := math.Abs(v)
-		if a < t.beta {
-			t.dy.Set(i, j, v)
-			return 0.5 * a * a / t.beta
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v := pred.At(i, j) - targ.At(i, j)
+			a := math.Abs(v)
+			if a < t.beta {
+				t.dy.Set(i, j, v/t.beta)
+				loss.Set(i, j, 0.5*a*a/t.beta)
+				continue
+			}
+			if v > 0 {
+				t.dy.Set(i, j, 1)
+			} else {
+				t.dy.Set(i, j, -1)
+			}
+			loss.Set(i, j, a-0.5*t.beta)
 		}
-		if v > 0 {
-			t.dy.Set(i, j, 1)
-		} else {
-			t.dy.Set(i, j, -1)
-		}
-		return a - 0.5*t.beta
-	}, loss)
+	}
 	return
 }
 
@@ -228,12 +367,17 @@ func (t *TargetSmoothMAE) Backward() (dy *mat.Dense) {
 // l2
 type TargetMSE struct {
 	sub *mat.Dense
+	ws  *common.Workspace
 }
 
 func NewTarMSE() *TargetMSE {
 	return &TargetMSE{}
 }
 
+func (t *TargetMSE) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
 func (t *TargetMSE) Loss(pred, targ *mat.Dense) (y float64) {
 	r, c := pred.Dims()
 	cnt := float64(r * c)
@@ -253,11 +397,391 @@ func (t *TargetMSE) Backward() (dy *mat.Dense) {
 
 func (t *TargetMSE) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
 	r, c := pred.Dims()
-	t.sub = mat.NewDense(r, c, nil)
-	t.sub.Sub(pred, targ)
-	loss = mat.NewDense(r, c, nil)
-	loss.Apply(func(i, j int, v float64) float64 {
-		return v * v * 0.5
-	}, t.sub)
+	t.sub = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v := pred.At(i, j) - targ.At(i, j)
+			t.sub.Set(i, j, v)
+			loss.Set(i, j, v*v*0.5)
+		}
+	}
+	return
+}
+
+// softmaxCol fills p, grown when short, with the softmax of column j
+func softmaxCol(p []float64, pred *mat.Dense, j int) []float64 {
+	r, _ := pred.Dims()
+	if cap(p) < r {
+		p = make([]float64, r)
+	}
+	p = mat.Col(p[:r], j, pred)
+	max := floats.Max(p)
+	for i := range p {
+		p[i] = math.Exp(p[i] - max)
+	}
+	floats.Scale(1/floats.Sum(p), p)
+	return p
+}
+
+// colMaxIdx is the row of the max in column j
+func colMaxIdx(m *mat.Dense, j int) (idx int) {
+	r, _ := m.Dims()
+	for i := 1; i < r; i++ {
+		if m.At(i, j) > m.At(idx, j) {
+			idx = i
+		}
+	}
+	return
+}
+
+// binary cross entropy on logits, every row is an independent label
+type TargetBCE struct {
+	dy *mat.Dense
+	ws *common.Workspace
+}
+
+func NewTarBCE() *TargetBCE {
+	return &TargetBCE{}
+}
+
+func (t *TargetBCE) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetBCE) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v, y := pred.At(i, j), targ.At(i, j)
+			t.dy.Set(i, j, 1/(1+math.Exp(-v))-y)
+			loss.Set(i, j, math.Max(v, 0)-v*y+math.Log1p(math.Exp(-math.Abs(v))))
+		}
+	}
+	return
+}
+
+func (t *TargetBCE) Loss(pred, targ *mat.Dense) (y float64) {
+	_, batch := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
+}
+
+func (t *TargetBCE) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+func (t *TargetBCE) Acc(pred, targ *mat.Dense) (acc float64) {
+	r, c := pred.Dims()
+	cnt := 0.0
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			if (pred.At(i, j) > 0) == (targ.At(i, j) > 0.5) {
+				cnt++
+			}
+		}
+	}
+	return cnt / float64(r*c)
+}
+
+// softmax focal loss, -alpha*(1-p)^gamma*log(p), gamma 0 and alpha 1 is cross entropy
+type TargetFocal struct {
+	alpha float64
+	gamma float64
+	dy    *mat.Dense
+	p     []float64
+	ws    *common.Workspace
+}
+
+func NewTarFocal(alpha, gamma float64) *TargetFocal {
+	return &TargetFocal{alpha: alpha, gamma: gamma}
+}
+
+func (t *TargetFocal) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetFocal) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for j := 0; j < c; j++ {
+		t.p = softmaxCol(t.p, pred, j)
+		p := t.p
+		//dz_i = sum_k(t_k*g_k*(δ_ik-p_i)), g_k = alpha*(gamma*(1-p_k)^(gamma-1)*p_k*log(p_k)-(1-p_k)^gamma)
+		sumG := 0.0
+		for k := 0; k < r; k++ {
+			y := targ.At(k, j)
+			if y == 0 {
+				continue
+			}
+			q := 1 - p[k]
+			logP := math.Log(math.Max(p[k], math.SmallestNonzeroFloat64))
+			loss.Set(k, j, -t.alpha*y*math.Pow(q, t.gamma)*logP)
+			g := -math.Pow(q, t.gamma)
+			if t.gamma != 0 && q > 0 {
+				g += t.gamma * math.Pow(q, t.gamma-1) * p[k] * logP
+			}
+			g *= t.alpha * y
+			t.dy.Set(k, j, g)
+			sumG += g
+		}
+		for i := 0; i < r; i++ {
+			t.dy.Set(i, j, t.dy.At(i, j)-sumG*p[i])
+		}
+	}
+	return
+}
+
+func (t *TargetFocal) Loss(pred, targ *mat.Dense) (y float64) {
+	_, batch := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
+}
+
+func (t *TargetFocal) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+func (t *TargetFocal) Acc(pred, targ *mat.Dense) (acc float64) {
+	return AccArgmax(pred, targ)
+}
+
+type TargetHuber struct {
+	delta float64
+	dy    *mat.Dense
+	ws    *common.Workspace
+}
+
+func NewTarHuber(delta float64) *TargetHuber {
+	return &TargetHuber{delta: delta}
+}
+
+func (t *TargetHuber) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetHuber) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			v := pred.At(i, j) - targ.At(i, j)
+			a := math.Abs(v)
+			if a <= t.delta {
+				t.dy.Set(i, j, v)
+				loss.Set(i, j, 0.5*v*v)
+				continue
+			}
+			if v > 0 {
+				t.dy.Set(i, j, t.delta)
+			} else {
+				t.dy.Set(i, j, -t.delta)
+			}
+			loss.Set(i, j, t.delta*(a-0.5*t.delta))
+		}
+	}
 	return
 }
+
+func (t *TargetHuber) Loss(pred, targ *mat.Dense) (y float64) {
+	r, c := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(r*c)
+}
+
+func (t *TargetHuber) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+func (t *TargetHuber) Acc(pred, targ *mat.Dense) (acc float64) {
+	return LossToAccLinear(t.LossEach(pred, targ))
+}
+
+// multi-class hinge, sum of max(0, margin+z_i-z_k) over the wrong classes i
+type TargetHinge struct {
+	margin float64
+	dy     *mat.Dense
+	ws     *common.Workspace
+}
+
+func NewTarHinge(margin float64) *TargetHinge {
+	return &TargetHinge{margin: margin}
+}
+
+func (t *TargetHinge) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetHinge) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for j := 0; j < c; j++ {
+		k := colMaxIdx(targ, j)
+		zk := pred.At(k, j)
+		for i := 0; i < r; i++ {
+			if i == k {
+				continue
+			}
+			v := t.margin + pred.At(i, j) - zk
+			if v <= 0 {
+				continue
+			}
+			loss.Set(i, j, v)
+			t.dy.Set(i, j, 1)
+			t.dy.Set(k, j, t.dy.At(k, j)-1)
+		}
+	}
+	return
+}
+
+func (t *TargetHinge) Loss(pred, targ *mat.Dense) (y float64) {
+	_, batch := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
+}
+
+func (t *TargetHinge) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+func (t *TargetHinge) Acc(pred, targ *mat.Dense) (acc float64) {
+	return AccArgmax(pred, targ)
+}
+
+// kl(targ||softmax(pred)), targ columns are distributions
+type TargetKL struct {
+	dy *mat.Dense
+	p  []float64
+	ws *common.Workspace
+}
+
+func NewTarKL() *TargetKL {
+	return &TargetKL{}
+}
+
+func (t *TargetKL) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetKL) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(r, c)
+	for j := 0; j < c; j++ {
+		t.p = softmaxCol(t.p, pred, j)
+		p := t.p
+		sumT := 0.0
+		for i := 0; i < r; i++ {
+			y := targ.At(i, j)
+			sumT += y
+			if y > 0 {
+				loss.Set(i, j, y*(math.Log(y)-math.Log(math.Max(p[i], math.SmallestNonzeroFloat64))))
+			}
+		}
+		for i := 0; i < r; i++ {
+			t.dy.Set(i, j, p[i]*sumT-targ.At(i, j))
+		}
+	}
+	return
+}
+
+func (t *TargetKL) Loss(pred, targ *mat.Dense) (y float64) {
+	_, batch := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
+}
+
+func (t *TargetKL) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+func (t *TargetKL) Acc(pred, targ *mat.Dense) (acc float64) {
+	return AccArgmax(pred, targ)
+}
+
+// cosine embedding, targ stacks the paired vector over a row of labels,
+// 1 - cos for the similar pairs labeled 1, max(0, cos - margin) for the dissimilar labeled -1
+type TargetCosine struct {
+	margin float64
+	dy     *mat.Dense
+	ws     *common.Workspace
+}
+
+func NewTarCosine(margin float64) *TargetCosine {
+	return &TargetCosine{margin: margin}
+}
+
+func (t *TargetCosine) SetWorkspace(ws *common.Workspace) {
+	t.ws = ws
+}
+
+func (t *TargetCosine) cos(pred, targ *mat.Dense, j int) (cos, na, nb, lab float64) {
+	r, _ := pred.Dims()
+	tr, _ := targ.Dims()
+	if tr != r+1 {
+		panic(fmt.Sprintf("cosine target need %d rows of the pair and the label, but %d", r+1, tr))
+	}
+	lab = targ.At(r, j)
+	if lab != 1 && lab != -1 {
+		panic(fmt.Sprintf("cosine label need 1 or -1, but %f", lab))
+	}
+	dot := 0.0
+	for i := 0; i < r; i++ {
+		a, b := pred.At(i, j), targ.At(i, j)
+		dot += a * b
+		na += a * a
+		nb += b * b
+	}
+	na, nb = math.Sqrt(na), math.Sqrt(nb)
+	if na == 0 || nb == 0 {
+		return 0, na, nb, lab
+	}
+	return dot / (na * nb), na, nb, lab
+}
+
+func (t *TargetCosine) LossEach(pred, targ *mat.Dense) (loss *mat.Dense) {
+	r, c := pred.Dims()
+	t.dy = t.ws.Dense(r, c)
+	loss = t.ws.Dense(1, c)
+	for j := 0; j < c; j++ {
+		cos, na, nb, lab := t.cos(pred, targ, j)
+		sign := -1.0
+		if lab > 0 {
+			loss.Set(0, j, 1-cos)
+		} else if cos > t.margin {
+			loss.Set(0, j, cos-t.margin)
+			sign = 1
+		} else {
+			continue
+		}
+		if na == 0 || nb == 0 {
+			continue
+		}
+		//dcos = b/(|a||b|)-cos*a/|a|^2
+		for i := 0; i < r; i++ {
+			t.dy.Set(i, j, sign*(targ.At(i, j)/(na*nb)-cos*pred.At(i, j)/(na*na)))
+		}
+	}
+	return
+}
+
+func (t *TargetCosine) Loss(pred, targ *mat.Dense) (y float64) {
+	_, batch := pred.Dims()
+	return mat.Sum(t.LossEach(pred, targ)) / float64(batch)
+}
+
+func (t *TargetCosine) Backward() (dy *mat.Dense) {
+	return t.dy
+}
+
+// share of the pairs whose cosine is above the margin exactly when labeled similar
+func (t *TargetCosine) Acc(pred, targ *mat.Dense) (acc float64) {
+	_, batch := pred.Dims()
+	for j := 0; j < batch; j++ {
+		cos, _, _, lab := t.cos(pred, targ, j)
+		if (cos > t.margin) == (lab > 0) {
+			acc++
+		}
+	}
+	return acc / float64(batch)
+}
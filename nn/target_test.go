@@ -0,0 +1,65 @@
+package nn
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestTargetWeightedCE(t *testing.T) {
+	pred := mat.NewDense(2, 3, []float64{
+		1, -1, 0.5,
+		-1, 2, 0.5,
+	})
+	targ := mat.NewDense(2, 3, []float64{
+		1, 0, 0,
+		0, 1, 1,
+	})
+	loss := NewTarCE().Loss(pred, targ)
+	scaled := NewTarWeightedCE([]float64{3, 3}, 0).Loss(pred, targ)
+	if math.Abs(loss-scaled) > 1e-12 {
+		t.Fatalf("uniform weights need same loss:%f but:%f", loss, scaled)
+	}
+
+	// one sample of class 0 weighted 2, two of class 1 weighted 1
+	ce := NewTarCE()
+	each := ce.LossEach(pred, targ)
+	weighted := NewTarWeightedCE([]float64{2, 1}, 0).Loss(pred, targ)
+	tar := (2*mat.Sum(each.ColView(0)) + mat.Sum(each.ColView(1)) + mat.Sum(each.ColView(2))) / 4
+	if math.Abs(weighted-tar) > 1e-12 {
+		t.Fatalf("weighted loss need:%f but:%f", tar, weighted)
+	}
+
+	smooth := NewTarWeightedCE(nil, 0.2)
+	smooth.Loss(pred, targ)
+	dy := smooth.Backward()
+	for j := 0; j < 3; j++ {
+		if math.Abs(mat.Sum(dy.ColView(j))) > 1e-12 {
+			t.Fatalf("smoothed gradient column %d need sum 0", j)
+		}
+	}
+	if w := InvFreqWeights([]*mat.Dense{targ}); math.Abs(w[0]-4.0/3) > 1e-12 || math.Abs(w[1]-2.0/3) > 1e-12 {
+		t.Fatalf("inverse frequency weights wrong:%v", w)
+	}
+}
+
+func TestTargetCEZeroColumn(t *testing.T) {
+	pred := mat.NewDense(2, 2, []float64{
+		1, 0.5,
+		-1, 2,
+	})
+	targ := mat.NewDense(2, 2, []float64{
+		1, 0,
+		0, 0,
+	})
+	ce := NewTarCE()
+	ce.Loss(pred, targ)
+	dy := ce.Backward()
+	for i := 0; i < 2; i++ {
+		soft := math.Exp(pred.At(i, 1)) / (math.Exp(0.5) + math.Exp(2))
+		if math.Abs(dy.At(i, 1)-soft) > 1e-12 {
+			t.Fatalf("zero target column need softmax gradient:%f but:%f", soft, dy.At(i, 1))
+		}
+	}
+}
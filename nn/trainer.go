@@ -0,0 +1,188 @@
+package nn
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"pneuma/common"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// validation runs every ValiFreq epochs, Patience counts validations without improvement
+type TrainerParam struct {
+	Epoch       int
+	ValiFreq    int
+	Patience    int
+	MinDelta    float64
+	Shuffle     bool
+	RestoreBest bool
+	Seed        int64
+}
+
+func NewTrainerParam() *TrainerParam {
+	return &TrainerParam{
+		Epoch:       16,
+		ValiFreq:    1,
+		Patience:    3,
+		MinDelta:    0.0001,
+		Shuffle:     true,
+		RestoreBest: true,
+	}
+}
+
+type TrainInfo struct {
+	Epoch     int
+	Batch     int
+	SpendMS   int
+	TrainLoss float64
+	Validated bool
+	ValiLoss  float64
+	ValiAcc   float64
+	BestLoss  float64
+	Stopped   bool
+	// an epoch without batches, or the checkpoint of the best validation
+	// failed to save or load, it stops training
+	Err error
+}
+
+type Trainer struct {
+	model      common.IModel
+	param      *TrainerParam
+	rnd        *rand.Rand
+	vali       func() (loss, acc float64)
+	onBatchEnd func(info *TrainInfo)
+	onEpochEnd func(info *TrainInfo)
+	info       TrainInfo
+	bad        int
+	best       *bytes.Buffer
+}
+
+func NewTrainer(m common.IModel, param *TrainerParam) *Trainer {
+	return &Trainer{
+		model: m,
+		param: param,
+		rnd:   rand.New(rand.NewSource(param.Seed)),
+		info:  TrainInfo{BestLoss: math.Inf(1)},
+	}
+}
+
+func (t *Trainer) Param() *TrainerParam {
+	return t.param
+}
+
+func (t *Trainer) Vali(vali func() (loss, acc float64)) {
+	t.vali = vali
+}
+
+func (t *Trainer) ValiSet(x, y []*mat.Dense) {
+	t.Vali(func() (loss, acc float64) {
+		return t.model.Tests(x, y)
+	})
+}
+
+func (t *Trainer) OnBatchEnd(cb func(info *TrainInfo)) {
+	t.onBatchEnd = cb
+}
+
+func (t *Trainer) OnEpochEnd(cb func(info *TrainInfo)) {
+	t.onEpochEnd = cb
+}
+
+func (t *Trainer) Fit(trainX, trainY []*mat.Dense) *TrainInfo {
+	for e := 0; e < t.param.Epoch && !t.info.Stopped; e++ {
+		t.Batches(trainX, trainY)
+		t.EpochEnd()
+	}
+	t.Finish()
+	return &t.info
+}
+
+// Batches trains one part of the current epoch, so data loaded piece by piece
+// can be fed before EpochEnd, it returns false once training stopped
+func (t *Trainer) Batches(trainX, trainY []*mat.Dense) bool {
+	order := make([]int, len(trainX))
+	for i := range order {
+		order[i] = i
+	}
+	if t.param.Shuffle {
+		t.rnd.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+	}
+	for _, idx := range order {
+		if t.info.Stopped {
+			return false
+		}
+		stTime := time.Now()
+		t.model.Train(trainX[idx], trainY[idx])
+		t.info.SpendMS = int(time.Since(stTime).Milliseconds())
+		t.info.TrainLoss = t.model.LossLatest()
+		if t.model.IsDone() {
+			t.info.Stopped = true
+		}
+		if t.onBatchEnd != nil {
+			t.onBatchEnd(&t.info)
+		}
+		t.info.Batch++
+	}
+	return !t.info.Stopped
+}
+
+func (t *Trainer) EpochEnd() bool {
+	// the mean of no loss is NaN, it would reach the schedulers and the validation
+	if t.info.Batch == 0 {
+		t.info.Err = fmt.Errorf("epoch %d has no batch", t.info.Epoch)
+		t.info.Stopped = true
+		return false
+	}
+	t.info.TrainLoss = t.model.LossPopMean()
+	t.model.EpochEnd(t.info.TrainLoss)
+	t.info.Validated = false
+	if t.param.ValiFreq > 0 && (t.info.Epoch+1)%t.param.ValiFreq == 0 {
+		t.validate()
+	}
+	if t.onEpochEnd != nil {
+		t.onEpochEnd(&t.info)
+	}
+	t.info.Epoch++
+	t.info.Batch = 0
+	return !t.info.Stopped
+}
+
+// Finish restores the model to its best validation, if asked
+func (t *Trainer) Finish() error {
+	if t.best != nil {
+		if err := t.model.Load(bytes.NewReader(t.best.Bytes())); err != nil {
+			t.info.Err = err
+		}
+	}
+	return t.info.Err
+}
+
+func (t *Trainer) validate() {
+	if t.vali == nil {
+		return
+	}
+	t.info.ValiLoss, t.info.ValiAcc = t.vali()
+	t.info.Validated = true
+	if t.info.ValiLoss < t.info.BestLoss-t.param.MinDelta {
+		t.info.BestLoss = t.info.ValiLoss
+		t.bad = 0
+		if t.param.RestoreBest {
+			t.best = &bytes.Buffer{}
+			if err := t.model.Save(t.best); err != nil {
+				t.best = nil
+				t.info.Err = err
+				t.info.Stopped = true
+			}
+		}
+		return
+	}
+	t.bad++
+	if t.param.Patience > 0 && t.bad >= t.param.Patience {
+		t.info.Stopped = true
+	}
+}
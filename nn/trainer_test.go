@@ -0,0 +1,97 @@
+package nn
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestTrainer(t *testing.T) {
+	x := mat.NewDense(3, 4, []float64{
+		0.1, 0.2, 0.3, 0.4,
+		0.5, 0.1, 0.2, 0.9,
+		0.3, 0.7, 0.8, 0.2,
+	})
+	y := mat.NewDense(2, 4, []float64{
+		1, 0, 1, 0,
+		0, 1, 0, 1,
+	})
+	trainX := []*mat.Dense{x, x, x}
+	trainY := []*mat.Dense{y, y, y}
+	m := newCheckpointModel(4)
+	param := NewTrainerParam()
+	param.Epoch = 5
+	trainer := NewTrainer(m, param)
+	trainer.ValiSet([]*mat.Dense{x}, []*mat.Dense{y})
+	batches, epochs := 0, 0
+	trainer.OnBatchEnd(func(info *TrainInfo) {
+		batches++
+	})
+	trainer.OnEpochEnd(func(info *TrainInfo) {
+		if !info.Validated {
+			t.Fatalf("epoch %d not validated", info.Epoch)
+		}
+		epochs++
+	})
+	info := trainer.Fit(trainX, trainY)
+	if batches != 15 || epochs != 5 || info.Stopped {
+		t.Fatalf("trainer run wrong, batches:%d, epochs:%d, stopped:%v", batches, epochs, info.Stopped)
+	}
+	if loss, _ := m.Tests([]*mat.Dense{x}, []*mat.Dense{y}); loss != info.BestLoss {
+		t.Fatalf("best model not restored, best:%f, now:%f", info.BestLoss, loss)
+	}
+
+	// vali loss rises while training, so it stops after patience
+	m = newCheckpointModel(4)
+	param.Patience = 2
+	param.Epoch = 100
+	trainer = NewTrainer(m, param)
+	worse := 0.0
+	trainer.Vali(func() (loss, acc float64) {
+		worse++
+		return worse, 0
+	})
+	info = trainer.Fit(trainX, trainY)
+	if !info.Stopped || info.Epoch != 3 || info.BestLoss != 1 {
+		t.Fatalf("early stop wrong, stopped:%v, epoch:%d, best:%f", info.Stopped, info.Epoch, info.BestLoss)
+	}
+}
+
+// saveFailModel fails every checkpoint
+type saveFailModel struct {
+	*Model
+}
+
+func (m saveFailModel) Save(w io.Writer) error {
+	return errors.New("disk full")
+}
+
+func TestTrainerSaveError(t *testing.T) {
+	x := mat.NewDense(3, 4, nil)
+	y := mat.NewDense(2, 4, []float64{
+		1, 0, 1, 0,
+		0, 1, 0, 1,
+	})
+	trainer := NewTrainer(saveFailModel{newCheckpointModel(4)}, NewTrainerParam())
+	trainer.ValiSet([]*mat.Dense{x}, []*mat.Dense{y})
+	info := trainer.Fit([]*mat.Dense{x}, []*mat.Dense{y})
+	if info.Err == nil || !info.Stopped || info.Epoch != 1 {
+		t.Fatalf("save error need stop after first epoch, err:%v, stopped:%v, epoch:%d", info.Err, info.Stopped, info.Epoch)
+	}
+}
+
+func TestTrainerEmptyEpoch(t *testing.T) {
+	m := newCheckpointModel(4)
+	trainer := NewTrainer(m, NewTrainerParam())
+	epochs := 0
+	trainer.OnEpochEnd(func(info *TrainInfo) {
+		epochs++
+	})
+	info := trainer.Fit(nil, nil)
+	if info.Err == nil || !info.Stopped || epochs != 0 || math.IsNaN(info.TrainLoss) {
+		t.Fatalf("empty epoch need error and stop, err:%v, stopped:%v, epochs:%d, loss:%f", info.Err, info.Stopped, epochs, info.TrainLoss)
+	}
+}
@@ -0,0 +1,115 @@
+package nn
+
+import (
+	"bytes"
+	"pneuma/common"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestModelWorkspace(t *testing.T) {
+	x := mat.NewDense(3, 6, []float64{
+		0.1, 0.2, 0.3, 0.4, 0.9, 0.3,
+		0.5, 0.1, 0.2, 0.9, 0.2, 0.6,
+		0.3, 0.7, 0.8, 0.2, 0.4, 0.1,
+	})
+	y := mat.NewDense(2, 6, []float64{
+		1, 0, 1, 0, 1, 1,
+		0, 1, 0, 1, 0, 0,
+	})
+	for _, cnt := range []int{1, 3} {
+		plain := newCheckpointModel(4)
+		buf := &bytes.Buffer{}
+		if err := plain.Save(buf); err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+		pooled := newCheckpointModel(4)
+		if err := Load(buf, pooled); err != nil {
+			t.Fatalf("load failed: %v", err)
+		}
+		plain.Parallel(cnt)
+		pooled.Parallel(cnt)
+		ws := common.NewWorkspace()
+		pooled.UseWorkspace(ws)
+		steady := 0
+		for i := 0; i < 5; i++ {
+			plainDx := plain.Train(x, y)
+			pooledDx := pooled.Train(x, y)
+			if !mat.EqualApprox(plainDx, pooledDx, 1e-12) {
+				t.Fatalf("parallel %d, dx at %d not equal need:\n%v\nbut:\n%v\n", cnt, i, mat.Formatted(plainDx), mat.Formatted(pooledDx))
+			}
+			if i == 1 {
+				steady = ws.Cap()
+			}
+			if i > 1 && ws.Cap() != steady {
+				t.Fatalf("parallel %d, workspace grows at %d, %d to %d", cnt, i, steady, ws.Cap())
+			}
+		}
+		pred := pooled.Predict(x)
+		pooled.Predict(x.Slice(0, 3, 0, 2).(*mat.Dense))
+		if !mat.EqualApprox(plain.Predict(x), pred, 1e-12) {
+			t.Fatalf("parallel %d, predict not equal or overwritten by the next one", cnt)
+		}
+	}
+	// replicas run on goroutines which allocate, a single model borrows all
+	m := newCheckpointModel(64)
+	m.UseWorkspace(common.NewWorkspace())
+	m.Train(x, y)
+	if allocs := testing.AllocsPerRun(10, func() { m.Train(x, y) }); allocs != 0 {
+		t.Fatalf("steady train allocates %v times", allocs)
+	}
+
+	// the pair of the cosine target is stacked over a row of labels
+	cosTarg := mat.NewDense(3, 6, nil)
+	cosTarg.Slice(0, 2, 0, 6).(*mat.Dense).Copy(y)
+	cosTarg.SetRow(2, []float64{1, -1, 1, -1, 1, -1})
+	cases := []struct {
+		name   string
+		hlayer common.IHLayer
+		tar    common.ITarget
+		targ   *mat.Dense
+	}{
+		{"prelu", NewHLayerPRelu(0.25), NewTarCE(), y},
+		{"softmax", NewHLayerSoftmax(), NewTarCE(), y},
+		{"layernorm", NewHLayerLayerNorm(0.0001), NewTarCE(), y},
+		{"weighted_ce", nil, NewTarWeightedCE([]float64{2, 1}, 0.1), y},
+		{"mae", nil, NewTarMAE(), y},
+		{"smoothmae", nil, NewTarSmoothMAE(0.5), y},
+		{"mse", nil, NewTarMSE(), y},
+		{"bce", nil, NewTarBCE(), y},
+		{"focal", nil, NewTarFocal(0.25, 2), y},
+		{"huber", nil, NewTarHuber(1), y},
+		{"hinge", nil, NewTarHinge(1), y},
+		{"kl", nil, NewTarKL(), y},
+		{"cosine", nil, NewTarCosine(0), cosTarg},
+	}
+	for _, c := range cases {
+		m := newAllocModel(16, c.hlayer, c.tar)
+		m.UseWorkspace(common.NewWorkspace())
+		m.Train(x, c.targ)
+		if allocs := testing.AllocsPerRun(10, func() { m.Train(x, c.targ) }); allocs != 0 {
+			t.Fatalf("%s steady train allocates %v times", c.name, allocs)
+		}
+	}
+}
+
+// linear, the hlayer if any, linear and the target
+func newAllocModel(hidden int, hlayer common.IHLayer, tar common.ITarget) *Model {
+	m := NewModel()
+	lin1 := NewHLayerLinear()
+	lin1.InitSize([]int{hidden, 3})
+	hlayers := []common.IHLayer{lin1}
+	if hlayer != nil {
+		if initer, ok := hlayer.(common.IHLayerSizeIniter); ok {
+			initer.InitSize([]int{hidden, 3})
+		}
+		hlayers = append(hlayers, hlayer)
+	}
+	m.AddLayer(NewOptMomentum(0.1, 0.5), hlayers...)
+	lin2 := NewHLayerLinear()
+	lin2.InitSize([]int{2, hidden})
+	m.AddLayer(NewOptMomentum(0.1, 0.5), lin2)
+	m.SetTarget(tar, NewLossParam())
+	return m
+}
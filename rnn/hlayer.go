@@ -1,28 +1,37 @@
 package rnn
 
 import (
-	"math/rand"
+	"fmt"
 	"pneuma/common"
 	"pneuma/nn"
 
 	"gonum.org/v1/gonum/mat"
 )
 
+// s = act(u*x + w*s_prev + b), y = outLay(s)
+// forward steps are kept until SeqReset, backward runs them in reverse
 type HLayerRNN struct {
-	outLay   *nn.HLayerLinear
-	u        *mat.Dense
-	w        *mat.Dense
-	b        *mat.VecDense
-	x        []*mat.Dense
-	s        []*mat.Dense
-	sPred    *mat.Dense
-	du       []*mat.Dense
-	dw       []*mat.Dense
-	db       []*mat.VecDense
-	diagSSum *mat.Dense
-	act      common.IHLayer
-	seqSize  int
-	seqIdx   int
+	outLay  *nn.HLayerLinear
+	u       *mat.Dense
+	w       *mat.Dense
+	b       *mat.VecDense
+	x       []*mat.Dense
+	z       []*mat.Dense
+	s       []*mat.Dense
+	sPred   *mat.Dense
+	ds      *mat.Dense
+	du      *mat.Dense
+	dw      *mat.Dense
+	db      *mat.VecDense
+	outD    []mat.Matrix
+	datas   []mat.Matrix
+	deltas  []mat.Matrix
+	act     common.IHLayer
+	initer  common.IInitializer
+	ws      *common.Workspace
+	seqSize int
+	seqIdx  int
+	predIdx int
 }
 
 func NewHLayerCommonRNN(seqSize int) *HLayerRNN {
@@ -33,113 +42,131 @@ func NewHLayerRNN(seqSize int, act common.IHLayer) *HLayerRNN {
 	return &HLayerRNN{
 		outLay:  nn.NewHLayerLinear(),
 		act:     act,
+		initer:  common.NewInitRand(),
 		seqSize: seqSize,
 	}
 }
 
+func (l *HLayerRNN) SetIniter(initer common.IInitializer) {
+	l.initer = initer
+	l.outLay.SetIniter(initer)
+}
+
+// the steps are kept by the layer, only what lives within one call is borrowed
+func (l *HLayerRNN) SetWorkspace(ws *common.Workspace) {
+	l.ws = ws
+	common.SetWorkspace(ws, l.act, l.outLay)
+}
+
 func (l *HLayerRNN) InitSize(size []int) []int {
 	r, c := size[0], size[1]
 	l.w = mat.NewDense(l.seqSize, l.seqSize, nil)
 	l.u = mat.NewDense(l.seqSize, r, nil)
 	l.b = mat.NewVecDense(l.seqSize, nil)
 	l.outLay.InitSize([]int{c, l.seqSize})
-	l.w.Apply(func(i, j int, v float64) float64 {
-		return rand.Float64() - 0.5
-	}, l.w)
-	l.u.Apply(func(i, j int, v float64) float64 {
-		return rand.Float64() - 0.5
-	}, l.w)
-	l.diagSSum = mat.NewDense(l.seqSize, l.seqSize, nil)
+	l.initer.Init(l.w, l.seqSize, l.seqSize)
+	l.initer.Init(l.u, r, l.seqSize)
+	l.du = mat.NewDense(l.seqSize, r, nil)
+	l.dw = mat.NewDense(l.seqSize, l.seqSize, nil)
+	l.db = mat.NewVecDense(l.seqSize, nil)
+	l.outD = newDeltas(l.outLay)
 	return size
 }
 
 func (l *HLayerRNN) SeqReset() {
-	l.dw = nil
-	l.du = nil
-	l.db = nil
-	l.s = nil
+	l.x = l.x[:0]
+	l.z = l.z[:0]
+	l.s = l.s[:0]
 	l.seqIdx = 0
-	l.sPred = nil
+	l.predIdx = 0
+}
+
+func (l *HLayerRNN) step(z, x, sPrev *mat.Dense) {
+	z.Mul(l.u, x)
+	if sPrev != nil {
+		ws := l.ws.Dense(z.Dims())
+		ws.Mul(l.w, sPrev)
+		z.Add(z, ws)
+	}
+	addCols(z, l.b)
 }
 
 func (l *HLayerRNN) Predict(x *mat.Dense) (y *mat.Dense) {
 	_, batch := x.Dims()
-	s := mat.NewDense(l.seqSize, batch, nil)
-	s.Mul(l.u, x)
-	if l.sPred != nil {
-		newS := mat.NewDense(l.seqSize, batch, nil)
-		newS.Mul(l.w, l.sPred)
-		s.Add(s, newS)
+	var sPrev *mat.Dense
+	if l.predIdx > 0 {
+		sPrev = l.sPred
 	}
-	s = l.act.Forward(s)
-	y = l.outLay.Forward(s)
-	l.sPred = s
+	z := l.ws.Dense(l.seqSize, batch)
+	l.step(z, x, sPrev)
+	l.sPred = reuseBuf(l.sPred, l.seqSize, batch)
+	l.sPred.Copy(common.Predic(l.act, z))
+	l.predIdx++
+	y = common.Predic(l.outLay, l.sPred)
 	return
 }
 
 func (l *HLayerRNN) Forward(x *mat.Dense) (y *mat.Dense) {
-	_, batch := x.Dims()
-	s := mat.NewDense(l.seqSize, batch, nil)
-	s.Mul(l.u, x)
-	if l.s != nil {
-		oldS := l.s[len(l.s)-1]
-		newS := mat.NewDense(l.seqSize, batch, nil)
-		newS.Mul(l.w, oldS)
-		s.Add(s, newS)
+	xr, batch := x.Dims()
+	var sPrev *mat.Dense
+	if len(l.s) > 0 {
+		sPrev = l.s[len(l.s)-1]
 	}
-	s = l.act.Forward(s)
-	l.s = append(l.s, s)
-	l.x = append(l.x, x)
+	var xt, z, s *mat.Dense
+	l.x, xt = stepBuf(l.x, xr, batch)
+	xt.Copy(x)
+	l.z, z = stepBuf(l.z, l.seqSize, batch)
+	l.step(z, xt, sPrev)
+	l.s, s = stepBuf(l.s, l.seqSize, batch)
+	s.Copy(l.act.Forward(z))
+	l.seqIdx = len(l.s)
 	y = l.outLay.Forward(s)
 	return
 }
 
+// each call takes the dy of the latest step not backwarded yet
 func (l *HLayerRNN) Backward(dy *mat.Dense) (dx *mat.Dense) {
-	_, batch := dy.Dims()
-	ds := l.act.Backward(l.outLay.Backward(dy))
-	ur, uc := l.u.Dims()
-	wr, wc := l.w.Dims()
-	du := mat.NewDense(ur, uc, nil)
-	dw := mat.NewDense(wr, wc, nil)
-	db := mat.NewVecDense(l.b.Len(), nil)
-	du.Mul(ds, l.x[l.seqIdx].T())
-	dw.Mul(ds, l.s[l.seqIdx].T())
-	for j := 0; j < batch; j++ {
-		db.AddVec(db, ds.ColView(j))
+	t := l.seqIdx - 1
+	if t < 0 {
+		panic(fmt.Sprintf("rnn backward steps more than forward, %d", len(l.s)))
+	}
+	last := t == len(l.s)-1
+	if last {
+		l.du.Zero()
+		l.dw.Zero()
+		l.db.Zero()
+		zeroDeltas(l.outD)
+	}
+	// the layers inside keep only the latest step, so they run it again
+	l.outLay.Forward(l.s[t])
+	ds := l.ws.DenseCopyOf(l.outLay.Backward(dy))
+	addDeltas(l.outD, l.outLay)
+	if !last {
+		ds.Add(ds, l.ds)
 	}
-	if l.seqIdx > 0 {
-		newDu := mat.NewDense(ur, uc, nil)
-		newDw := mat.NewDense(wr, wc, nil)
-		newDb := mat.NewVecDense(l.b.Len(), nil)
-		for i := 0; i < l.b.Len(); i++ {
-			l.diagSSum.Set(i, i, l.b.AtVec(i))
-		}
-		wt := l.w.T()
-		newDu.Mul(wt, l.du[l.seqIdx-1])
-		newDu.Mul(l.diagSSum, newDu)
-		du.Add(du, newDu)
-		newDw.Mul(wt, l.dw[l.seqIdx-1])
-		newDw.Mul(l.diagSSum, newDw)
-		dw.Add(dw, newDw)
-		newDb.MulVec(wt, l.db[l.seqIdx-1])
-		newDb.MulVec(l.diagSSum, newDb)
-		db.AddVec(db, newDb)
+	l.act.Forward(l.z[t])
+	dz := l.act.Backward(ds)
+	addMulT(l.ws, l.du, dz, l.x[t])
+	if t > 0 {
+		addMulT(l.ws, l.dw, dz, l.s[t-1])
 	}
-	l.du = append(l.du, du)
-	l.dw = append(l.dw, dw)
-	l.db = append(l.db, db)
-	l.seqIdx++
+	addRows(l.db, dz)
+	_, batch := dz.Dims()
+	l.ds = reuseBuf(l.ds, l.seqSize, batch)
+	common.MulTrans(l.ds, l.w, dz, true, false)
+	xr, _ := l.x[t].Dims()
+	dx = l.ws.Dense(xr, batch)
+	common.MulTrans(dx, l.u, dz, true, false)
+	l.seqIdx--
 	return
 }
 
+// the slices are kept by the layer and refilled by the next call
 func (l *HLayerRNN) Optimize() (datas, deltas []mat.Matrix) {
-	datas = []mat.Matrix{
-		l.w, l.u, l.b,
-	}
-	deltas = []mat.Matrix{
-		SumDense(l.dw), SumDense(l.du), SumVecDense(l.db),
-	}
-	return
+	outDatas, _ := l.outLay.Optimize()
+	l.datas = append(append(l.datas[:0], l.w, l.u, l.b), outDatas...)
+	l.deltas = append(append(l.deltas[:0], l.dw, l.du, l.db), l.outD...)
+	return l.datas, l.deltas
 }
 
 type HLayerLSTM struct {
@@ -0,0 +1,69 @@
+package rnn
+
+import (
+	"pneuma/common"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type seqHLayer interface {
+	common.IHLayerOptimizer
+	common.IHLayerWorkspacer
+	InitSize([]int) []int
+	SetIniter(initer common.IInitializer)
+	SeqReset()
+}
+
+// one training step over the sequence, the deltas are summed through time
+func runSeq(l seqHLayer, ws *common.Workspace, xs, ys, dxs []*mat.Dense) {
+	ws.Reset()
+	l.SeqReset()
+	for t := range xs {
+		ys[t] = l.Forward(xs[t])
+	}
+	for t := len(xs) - 1; t >= 0; t-- {
+		dxs[t] = l.Backward(ys[t])
+	}
+}
+
+func TestSeqWorkspace(t *testing.T) {
+	xs := []*mat.Dense{
+		mat.NewDense(3, 2, []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6}),
+		mat.NewDense(3, 2, []float64{0.6, 0.1, 0.9, 0.2, 0.3, 0.7}),
+		mat.NewDense(3, 2, []float64{0.2, 0.8, 0.4, 0.1, 0.7, 0.5}),
+	}
+	layers := map[string]func() seqHLayer{
+		"rnn": func() seqHLayer { return NewHLayerCommonRNN(4) },
+	}
+	for name, newLayer := range layers {
+		plain, pooled := newLayer(), newLayer()
+		for _, l := range []seqHLayer{plain, pooled} {
+			l.SetIniter(common.NewInitConst(0.3))
+			l.InitSize([]int{3, 2})
+		}
+		ws := common.NewWorkspace()
+		pooled.SetWorkspace(ws)
+		ys := make([]*mat.Dense, len(xs))
+		need, dxs := make([]*mat.Dense, len(xs)), make([]*mat.Dense, len(xs))
+		for i := 0; i < 2; i++ {
+			runSeq(plain, nil, xs, ys, need)
+			runSeq(pooled, ws, xs, ys, dxs)
+			for k := range need {
+				if !mat.EqualApprox(need[k], dxs[k], 1e-12) {
+					t.Fatalf("%s dx of step %d with workspace need:\n%v\nbut:\n%v\n", name, k, mat.Formatted(need[k]), mat.Formatted(dxs[k]))
+				}
+			}
+			_, needDeltas := plain.Optimize()
+			_, deltas := pooled.Optimize()
+			for k := range needDeltas {
+				if !mat.EqualApprox(needDeltas[k], deltas[k], 1e-12) {
+					t.Fatalf("%s delta %d with workspace not equal", name, k)
+				}
+			}
+		}
+		if allocs := testing.AllocsPerRun(10, func() { runSeq(pooled, ws, xs, ys, dxs) }); allocs != 0 {
+			t.Fatalf("%s steady sequence allocates %v times", name, allocs)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package rnn
 
 import (
+	"pneuma/common"
+
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -46,3 +48,99 @@ func SumVecDense(vec []*mat.VecDense) (ret *mat.VecDense) {
 	}
 	return
 }
+
+// deltas of the layer summed over the steps, shaped as its datas
+func newDeltas(l common.IHLayerOptimizer) []mat.Matrix {
+	datas, _ := l.Optimize()
+	ret := make([]mat.Matrix, len(datas))
+	for i, data := range datas {
+		switch d := data.(type) {
+		case *mat.VecDense:
+			ret[i] = mat.NewVecDense(d.Len(), nil)
+		default:
+			r, c := d.Dims()
+			ret[i] = mat.NewDense(r, c, nil)
+		}
+	}
+	return ret
+}
+
+func zeroDeltas(deltas []mat.Matrix) {
+	for _, delta := range deltas {
+		switch d := delta.(type) {
+		case *mat.VecDense:
+			d.Zero()
+		case *mat.Dense:
+			d.Zero()
+		}
+	}
+}
+
+func addDeltas(deltas []mat.Matrix, l common.IHLayerOptimizer) {
+	_, step := l.Optimize()
+	for i, delta := range deltas {
+		switch d := delta.(type) {
+		case *mat.VecDense:
+			d.AddVec(d, step[i].(*mat.VecDense))
+		case *mat.Dense:
+			d.Add(d, step[i])
+		}
+	}
+}
+
+// dst += a*b^T, the product is borrowed from ws
+func addMulT(ws *common.Workspace, dst, a, b *mat.Dense) {
+	ab := ws.Dense(dst.Dims())
+	common.MulTrans(ab, a, b, false, true)
+	dst.Add(dst, ab)
+}
+
+// dst += the sum of each row of m
+func addRows(dst *mat.VecDense, m *mat.Dense) {
+	r, c := m.Dims()
+	for i := 0; i < r; i++ {
+		sum := 0.0
+		for j := 0; j < c; j++ {
+			sum += m.At(i, j)
+		}
+		dst.SetVec(i, dst.AtVec(i)+sum)
+	}
+}
+
+// each column of m += v
+func addCols(m *mat.Dense, v *mat.VecDense) {
+	r, c := m.Dims()
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			m.Set(i, j, m.At(i, j)+v.AtVec(i))
+		}
+	}
+}
+
+// stepBuf appends a r*c buffer of a step to bufs, reusing the one a former
+// sequence left beyond len, SeqReset only truncates the steps
+func stepBuf(bufs []*mat.Dense, r, c int) ([]*mat.Dense, *mat.Dense) {
+	n := len(bufs)
+	if n < cap(bufs) {
+		bufs = bufs[:n+1]
+		if d := bufs[n]; d != nil {
+			if dr, dc := d.Dims(); dr == r && dc == c {
+				return bufs, d
+			}
+		}
+		bufs[n] = mat.NewDense(r, c, nil)
+		return bufs, bufs[n]
+	}
+	d := mat.NewDense(r, c, nil)
+	return append(bufs, d), d
+}
+
+// reuseBuf gives d if it is r*c, a new one otherwise
+func reuseBuf(d *mat.Dense, r, c int) *mat.Dense {
+	if d != nil {
+		if dr, dc := d.Dims(); dr == r && dc == c {
+			return d
+		}
+	}
+	return mat.NewDense(r, c, nil)
+}
@@ -7,6 +7,7 @@ import (
 	"pneuma/cnn"
 	"pneuma/common"
 	"pneuma/cu"
+	"pneuma/metrics"
 	"pneuma/nn"
 	"pneuma/sample"
 )
@@ -76,34 +77,44 @@ func handwritten() {
 	b.FLay(func() common.IHLayer { return nn.NewHLayerRelu() })
 	//b.Optimizer(func() common.IOptimizer { return nn.NewOptMomentum(learingRate, optMT) })
 	//b.Optimizer(func() common.IOptimizer { return nn.NewOptNormal(learingRate) })
-	b.FOpt(func() common.IOptimizer { return nn.NewOptNormal(learingRate) })
+	b.FOpt(func() common.IOptimizer {
+		return nn.NewOptSchedule(nn.NewOptNormal(learingRate), nn.NewLRPlateau(0.5, 1, 0.001, learingRate*0.01), true)
+	})
 	b.Tar(nn.NewTarCE())
 	m := b.Build()
+	fmt.Printf("model:\n%s", m.Summary())
 
 	lineChart := sample.NewLineChart("handwritten")
 	lineChart.Reg("acc_vali", "acc_test", "loss_train", "loss_vali", "loss_test")
 	fmt.Printf("train start\n")
-	for e := 0; e < epoch; e++ {
-		if e >= lineChartChild {
-			m.Trains(trainx, trainy)
-		} else {
-			sampFreq := int(float64(len(trainx)) / samplingRate)
-			m.TrainTimes(trainx, trainy, func(trainTimes, spendMS int) {
-				if trainTimes%sampFreq == 0 {
-					child := lineChart.Child(e)
-					vloss, vacc := m.Tests(valix, valiy)
-					tloss, tacc := m.Tests(testx, testy)
-					child.Append(vacc, tacc, m.LossLatest(), vloss, tloss)
-					fmt.Printf("train at:%d, trainTimes:%d, test info :%s, spend:%d\n", e, trainTimes, child.Format(child.Len()-1), spendMS)
-				}
-			})
+	sampFreq := int(float64(len(trainx)) / samplingRate)
+	param := nn.NewTrainerParam()
+	param.Epoch = epoch
+	trainer := nn.NewTrainer(m, param)
+	trainer.ValiSet(valix, valiy)
+	trainer.OnBatchEnd(func(info *nn.TrainInfo) {
+		if info.Epoch >= lineChartChild || info.Batch%sampFreq != 0 {
+			return
 		}
+		child := lineChart.Child(info.Epoch)
 		vloss, vacc := m.Tests(valix, valiy)
 		tloss, tacc := m.Tests(testx, testy)
-		lineChart.Append(vacc, tacc, m.LossPopMean(), vloss, tloss)
-		fmt.Printf("train at:%d, %s\n", e, lineChart.Format(lineChart.Len()-1))
+		child.Append(vacc, tacc, info.TrainLoss, vloss, tloss)
+		fmt.Printf("train at:%d, trainTimes:%d, test info :%s, spend:%d\n", info.Epoch, info.Batch, child.Format(child.Len()-1), info.SpendMS)
+	})
+	trainer.OnEpochEnd(func(info *nn.TrainInfo) {
+		tloss, tacc := m.Tests(testx, testy)
+		lineChart.Append(info.ValiAcc, tacc, info.TrainLoss, info.ValiLoss, tloss)
+		fmt.Printf("train at:%d, %s\n", info.Epoch, lineChart.Format(lineChart.Len()-1))
+	})
+	if info := trainer.Fit(trainx, trainy); info.Err != nil {
+		panic(info.Err)
 	}
 	fmt.Printf("train end\n")
+	testPred := m.Predicts(testx)
+	conf := metrics.NewConfusion(len(labels))
+	conf.Adds(testPred, testy)
+	fmt.Printf("test report:\n%stop2:%.4f, auc:%.4f\n", conf, metrics.TopKs(testPred, testy, 2), metrics.MacroAUC(testPred, testy))
 	lineChart.Draw()
 }
 
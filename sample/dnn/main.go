@@ -6,6 +6,7 @@ import (
 	"pneuma/dnn"
 	"pneuma/nn"
 	"pneuma/sample"
+	"runtime"
 
 	"gonum.org/v1/gonum/mat"
 )
@@ -32,13 +33,18 @@ func main() {
 	builder.Size(trainSamp[0].X.Len(), 16, 10, 2)
 	//builder.Optimizer(func() nn.IOptimizer { return nn.NewOptNormal(0.01) })
 	builder.Opt(func() common.IOptimizer { return nn.NewOptMomentum(0.01, 0.1) })
+	builder.Init(func() common.IInitializer { return common.NewInitXavier(false) })
 	builder.Lay(func() common.IHLayer { return nn.NewHLayerBatchNorm(0.0001, 0.9) })
 	//builder.Layer(func() nn.IHLayer { return nn.NewHLayerRelu() })
 	builder.Lay(func() common.IHLayer { return nn.NewHLayerSigmoid() })
-	builder.Target(func() common.ITarget { return nn.NewTarCE() })
+	weights := nn.InvFreqWeights(trainy)
+	builder.Target(func() common.ITarget { return nn.NewTarWeightedCE(weights, 0.05) })
 
 	m := builder.Build()
+	fmt.Printf("model:\n%s", m.Summary())
 	dnn.NewIniSAE(m).Init(trainx)
+	m.Parallel(runtime.NumCPU())
+	m.UseWorkspace(common.NewWorkspace())
 
 	lineChart := sample.NewLineChart("krk")
 	lineChart.Reg("acc_vali", "acc_test", "loss_train")
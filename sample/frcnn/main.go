@@ -56,31 +56,46 @@ func voc() {
 	})
 
 	m := b.Build()
+	fmt.Printf("model:\n%s", m.Summary())
 	lineChart := sample.NewLineChart("voc")
 	lineChart.Reg("acc_vali", "acc_test", "loss_train", "loss_vali", "loss_test")
 	fmt.Printf("train start\n")
+	sampFreq := int(float64(trainCnt/loadCnt) / samplingRate)
+	param := nn.NewTrainerParam()
+	param.Epoch = epoch
+	trainer := nn.NewTrainer(m, param)
+	trainer.Vali(func() (loss, acc float64) {
+		dataSet.Valids.ResetLoad()
+		return testRecu(m, dataSet.Valids, valiCnt, loadCnt, batch)
+	})
+	trainer.OnEpochEnd(func(info *nn.TrainInfo) {
+		dataSet.Tests.ResetLoad()
+		lossTest, accTest := testRecu(m, dataSet.Tests, testCnt, loadCnt, batch)
+		lineChart.Append(info.ValiAcc, accTest, info.TrainLoss, info.ValiLoss, lossTest)
+		fmt.Printf("train at:%d, %s\n", info.Epoch, lineChart.Format(lineChart.Len()-1))
+	})
+	// Fit needs every batch in memory, the voc set is loaded loadCnt images
+	// at a time, so each piece is fed to Batches and the epoch closed by EpochEnd
 	for e := 0; e < epoch; e++ {
 		dataSet.Trains.ResetLoad()
-		if e >= lineChartChild {
-			makeSampleRecu(dataSet.Trains, trainCnt, loadCnt, batch, func(trainx, trainy []*mat.Dense) {
-				m.Trains(trainx, trainy)
-			})
-		} else {
-			trainTimes := 0
-			sampFreq := int(float64(trainCnt/loadCnt) / samplingRate)
-			makeSampleRecu(dataSet.Trains, trainCnt, loadCnt, batch, func(trainx, trainy []*mat.Dense) {
-				m.Trains(trainx, trainy)
-				if trainTimes%sampFreq == 0 {
-					child := lineChart.Child(e)
-					testModel(child, m, dataSet, loadCnt, batch, valiCntPTime, testCntPTime)
-					fmt.Printf("test at:%d, trainTimes:%d, test info :%s\n", e, trainTimes, child.Format(child.Len()-1))
-				}
-				trainTimes++
-			})
+		trainTimes := 0
+		makeSampleRecu(dataSet.Trains, trainCnt, loadCnt, batch, func(trainx, trainy []*mat.Dense) {
+			if !trainer.Batches(trainx, trainy) {
+				return
+			}
+			if e < lineChartChild && trainTimes%sampFreq == 0 {
+				child := lineChart.Child(e)
+				testModel(child, m, dataSet, loadCnt, batch, valiCntPTime, testCntPTime)
+				fmt.Printf("test at:%d, trainTimes:%d, test info :%s\n", e, trainTimes, child.Format(child.Len()-1))
+			}
+			trainTimes++
+		})
+		if !trainer.EpochEnd() {
+			break
 		}
-		testModel(lineChart, m, dataSet, loadCnt, batch, valiCnt, testCnt)
-		lineChart.Set(2, m.LossPopMean())
-		fmt.Printf("train at:%d, %s\n", e, lineChart.Format(lineChart.Len()-1))
+	}
+	if err := trainer.Finish(); err != nil {
+		panic(err)
 	}
 	fmt.Printf("train end\n")
 	lineChart.Draw()